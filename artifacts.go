@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	// keys without hash preferences default to RIPEMD160
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// artifactWriter writes files produced by a run, such as hook backups and reports. These contain
+// webhook urls which are effectively bearer credentials, so they are encrypted when recipients are
+// given. Artifacts are also uploaded to a destination at the end of a run if one is given.
+type artifactWriter struct {
+	recipients openpgp.EntityList
+	dest       artifactUploader
+
+	// the latest contents of each artifact, by name
+	names    []string
+	contents map[string][]byte
+}
+
+// newArtifactWriter loads the armored openpgp public keys that artifacts are encrypted to
+func newArtifactWriter(publicKeyFiles []string, dest artifactUploader) (*artifactWriter, error) {
+	a := &artifactWriter{dest: dest, contents: map[string][]byte{}}
+	for _, path := range publicKeyFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read public key %s: %v", path, err)
+		}
+		a.recipients = append(a.recipients, entities...)
+	}
+	return a, nil
+}
+
+// path returns where an artifact will be written, encrypted artifacts have an .asc suffix
+func (a *artifactWriter) path(path string) string {
+	if len(a.recipients) > 0 {
+		return path + ".asc"
+	}
+	return path
+}
+
+// writeFile writes an artifact locally and keeps it for uploading
+func (a *artifactWriter) writeFile(path string, data []byte) error {
+	data, err := a.store(filepath.Base(path), data)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.path(path), data, 0600)
+}
+
+// store keeps an artifact for uploading without writing it locally, returning its
+// contents which are encrypted if needed
+func (a *artifactWriter) store(name string, data []byte) ([]byte, error) {
+	if len(a.recipients) > 0 {
+		encrypted, err := a.encrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encrypt %s: %v", name, err)
+		}
+		data = encrypted
+	}
+
+	name = a.path(name)
+	if _, ok := a.contents[name]; !ok {
+		a.names = append(a.names, name)
+	}
+	a.contents[name] = data
+
+	return data, nil
+}
+
+// upload sends all the artifacts to the destination, if there is one
+func (a *artifactWriter) upload(ctx context.Context) error {
+	if a.dest == nil {
+		return nil
+	}
+	for _, name := range a.names {
+		log.Printf("Uploading %s to %s", name, a.dest)
+		if err := a.dest.upload(ctx, name, a.contents[name]); err != nil {
+			return fmt.Errorf("Failed to upload %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (a *artifactWriter) encrypt(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	armored, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openpgp.Encrypt(armored, a.recipients, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = armored.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// auditLog is an append-only log of rotations, one json entry per line. Each entry includes
+// the hash of the one before it, so removing or modifying entries breaks the chain, and entries
+// are optionally signed with an ed25519 key.
+type auditLog struct {
+	path     string
+	key      ed25519.PrivateKey
+	lastHash string
+}
+
+type auditEntry struct {
+	Time         time.Time    `json:"time"`
+	Organization string       `json:"organization"`
+	Pipeline     string       `json:"pipeline"`
+	PipelineID   string       `json:"pipeline_id"`
+	Outcome      string       `json:"outcome"`
+	Reason       string       `json:"reason,omitempty"`
+	Hooks        []hookResult `json:"hooks,omitempty"`
+	Version      string       `json:"version,omitempty"`
+	OldToken     string       `json:"old_token_fingerprint,omitempty"`
+	NewToken     string       `json:"new_token_fingerprint,omitempty"`
+	PreviousHash string       `json:"previous_hash"`
+	Hash         string       `json:"hash"`
+	Signature    string       `json:"signature,omitempty"`
+}
+
+// openAuditLog continues the chain from the last entry of an existing log
+func openAuditLog(path string, key ed25519.PrivateKey) (*auditLog, error) {
+	entries, err := readAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l := &auditLog{path: path, key: key}
+	if len(entries) > 0 {
+		l.lastHash = entries[len(entries)-1].Hash
+	}
+	return l, nil
+}
+
+func (l *auditLog) record(org string, result pipelineResult) error {
+	entry := auditEntry{
+		Time:         time.Now().UTC(),
+		Organization: org,
+		Pipeline:     result.Pipeline,
+		PipelineID:   result.PipelineID,
+		Outcome:      result.Outcome,
+		Reason:       result.Reason,
+		Hooks:        result.Hooks,
+		Version:      versionString(),
+		OldToken:     result.OldTokenFingerprint,
+		NewToken:     result.NewTokenFingerprint,
+		PreviousHash: l.lastHash,
+	}
+	entry.Hash = entry.hash()
+	if l.key != nil {
+		entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, []byte(entry.Hash)))
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	l.lastHash = entry.Hash
+	return nil
+}
+
+// hash covers everything in the entry except the hash and signature themselves
+func (e auditEntry) hash() string {
+	e.Hash, e.Signature = "", ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func readAuditLog(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Failed to parse audit log line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// verifyAuditLog checks the hash chain of an audit log, and the signature of every
+// entry if a public key is given
+func verifyAuditLog(path string, publicKey ed25519.PublicKey) (int, error) {
+	entries, err := readAuditLog(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var previousHash string
+	for i, entry := range entries {
+		if entry.PreviousHash != previousHash {
+			return i, fmt.Errorf("Entry %d doesn't follow the entry before it, entries have been removed or reordered", i+1)
+		}
+		if entry.hash() != entry.Hash {
+			return i, fmt.Errorf("Entry %d has been modified", i+1)
+		}
+		if publicKey != nil {
+			sig, err := base64.StdEncoding.DecodeString(entry.Signature)
+			if err != nil || !ed25519.Verify(publicKey, []byte(entry.Hash), sig) {
+				return i, fmt.Errorf("Entry %d has an invalid signature", i+1)
+			}
+		}
+		previousHash = entry.Hash
+	}
+
+	return len(entries), nil
+}
+
+// readEd25519Key reads a base64 encoded ed25519 key from a file, either a 32 byte
+// seed or a 64 byte private key, or a 32 byte public key
+func readEd25519Key(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode key %s: %v", path, err)
+	}
+	return key, nil
+}
+
+func readAuditSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readEd25519Key(path)
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	default:
+		return nil, fmt.Errorf("Expected a %d byte ed25519 seed or %d byte private key in %s",
+			ed25519.SeedSize, ed25519.PrivateKeySize, path)
+	}
+}
+
+func readAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := readEd25519Key(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Expected a %d byte ed25519 public key in %s", ed25519.PublicKeySize, path)
+	}
+	return ed25519.PublicKey(key), nil
+}
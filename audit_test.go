@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// writeTestAuditLog records a few rotations to a new audit log, returning its path and lines
+func writeTestAuditLog(t *testing.T, key ed25519.PrivateKey) (string, []string) {
+	t.Helper()
+	path := writeTempFile(t, "")
+	for _, slug := range []string{"web", "api"} {
+		// each record opens the log again, like separate runs do
+		l, err := openAuditLog(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.record("acme", pipelineResult{Pipeline: slug, PipelineID: slug + "-id", Outcome: outcomeRotated}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.record("acme", pipelineResult{Pipeline: slug + "-docs", PipelineID: slug + "-docs-id", Outcome: outcomeSkipped, Reason: "No hooks"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editEntry := func(line string, edit func(*auditEntry)) string {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		edit(&entry)
+		b, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		signed    bool
+		publicKey ed25519.PublicKey
+		tamper    func([]string) []string
+		err       string
+	}{
+		{name: "unsigned", signed: false},
+		{name: "signed", signed: true, publicKey: public},
+		{name: "signed without checking signatures", signed: true},
+		{
+			name: "modified entry",
+			tamper: func(lines []string) []string {
+				lines[1] = editEntry(lines[1], func(e *auditEntry) { e.Outcome = outcomeRotated })
+				return lines
+			},
+			err: "Entry 2 has been modified",
+		},
+		{
+			name: "modified entry with its hash updated",
+			tamper: func(lines []string) []string {
+				lines[1] = editEntry(lines[1], func(e *auditEntry) { e.Outcome = outcomeRotated; e.Hash = e.hash() })
+				return lines
+			},
+			err: "Entry 3 doesn't follow the entry before it",
+		},
+		{
+			name:      "modified last entry with its hash updated and signed",
+			signed:    true,
+			publicKey: public,
+			tamper: func(lines []string) []string {
+				lines[3] = editEntry(lines[3], func(e *auditEntry) { e.Reason = ""; e.Hash = e.hash() })
+				return lines
+			},
+			err: "Entry 4 has an invalid signature",
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			err: "Entry 2 doesn't follow the entry before it",
+		},
+		{
+			name: "removed first entry",
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+			err: "Entry 1 doesn't follow the entry before it",
+		},
+		{
+			name: "reordered entries",
+			tamper: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			err: "Entry 2 doesn't follow the entry before it",
+		},
+		{
+			name:      "unsigned entry",
+			signed:    true,
+			publicKey: public,
+			tamper: func(lines []string) []string {
+				lines[2] = editEntry(lines[2], func(e *auditEntry) { e.Signature = "" })
+				return lines
+			},
+			err: "Entry 3 has an invalid signature",
+		},
+		{
+			name:      "signed with another key",
+			signed:    true,
+			publicKey: otherPublic,
+			err:       "Entry 1 has an invalid signature",
+		},
+		{
+			name:      "unsigned log checked with a key",
+			publicKey: public,
+			err:       "Entry 1 has an invalid signature",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var key ed25519.PrivateKey
+			if tc.signed {
+				key = private
+			}
+			path, lines := writeTestAuditLog(t, key)
+			defer os.Remove(path)
+			if len(lines) != 4 {
+				t.Fatalf("Expected 4 entries, got %d", len(lines))
+			}
+
+			if tc.tamper != nil {
+				if err := ioutil.WriteFile(path, []byte(strings.Join(tc.tamper(lines), "\n")+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := verifyAuditLog(path, tc.publicKey)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 4 {
+					t.Fatalf("Expected 4 entries to be verified, got %d", n)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Fatalf("Expected %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
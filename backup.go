@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// hookBackup is a local snapshot of the full config of every hook before it's edited
+type hookBackup struct {
+	path      string
+	artifacts *artifactWriter
+	entries   []hookBackupEntry
+}
+
+type hookBackupEntry struct {
+	Pipeline   string       `json:"pipeline"`
+	Repository string       `json:"repository"`
+	BackedUpAt time.Time    `json:"backed_up_at"`
+	Hook       *github.Hook `json:"hook"`
+}
+
+func newHookBackup(dir string, artifacts *artifactWriter) *hookBackup {
+	name := fmt.Sprintf("hook-backup-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+	return &hookBackup{path: filepath.Join(dir, name), artifacts: artifacts}
+}
+
+// add backs up the hooks for a pipeline, rewriting the whole backup file each time so
+// it's complete even if the run is interrupted
+func (b *hookBackup) add(p pipeline, matches []githubRepositoryHook) error {
+	for _, match := range matches {
+		b.entries = append(b.entries, hookBackupEntry{
+			Pipeline:   p.String(),
+			Repository: match.githubRepository.String(),
+			BackedUpAt: time.Now().UTC(),
+			Hook:       match.Hook,
+		})
+	}
+
+	data, err := json.MarshalIndent(b.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := b.artifacts.writeFile(b.path, data); err != nil {
+		return fmt.Errorf("Error writing hook backup: %v", err)
+	}
+	return nil
+}
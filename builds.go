@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/buildkite/cli/graphql"
+)
+
+// webhookBuild is a build that was created by a github webhook delivery
+type webhookBuild struct {
+	URL       string
+	CreatedAt time.Time
+}
+
+func listWebhookBuilds(client *graphql.Client, p pipeline, since time.Time) ([]webhookBuild, error) {
+	resp, err := client.Do(`
+	query WebhookBuilds($pipeline: ID!, $since: DateTime) {
+		pipeline(slug: $pipeline) {
+			builds(first: 20, createdAtFrom: $since) {
+				edges {
+					node {
+						url
+						createdAt
+						source {
+							__typename
+						}
+					}
+				}
+			}
+		}
+	}
+	`, map[string]interface{}{
+		`pipeline`: p.String(),
+		`since`:    since.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedResp struct {
+		Data struct {
+			Pipeline struct {
+				Builds struct {
+					Edges []struct {
+						Node struct {
+							URL       string    `json:"url"`
+							CreatedAt time.Time `json:"createdAt"`
+							Source    struct {
+								Typename string `json:"__typename"`
+							} `json:"source"`
+						} `json:"node"`
+					} `json:"edges"`
+				} `json:"builds"`
+			} `json:"pipeline"`
+		} `json:"data"`
+	}
+
+	if err = resp.DecodeInto(&parsedResp); err != nil {
+		return nil, fmt.Errorf("Failed to parse GraphQL response: %v", err)
+	}
+
+	var builds []webhookBuild
+	for _, edge := range parsedResp.Data.Pipeline.Builds.Edges {
+		if edge.Node.Source.Typename != "BuildSourceWebhook" {
+			continue
+		}
+		builds = append(builds, webhookBuild{
+			URL:       edge.Node.URL,
+			CreatedAt: edge.Node.CreatedAt,
+		})
+	}
+	return builds, nil
+}
+
+// waitForWebhookBuild waits until a webhook triggered build is created for a pipeline after
+// it was rotated, which is end-to-end proof that github is delivering to the new webhook
+func waitForWebhookBuild(client *graphql.Client, p pipeline, since time.Time, timeout time.Duration) (webhookBuild, error) {
+	log.Printf("Waiting up to %v for a webhook triggered build of https://buildkite.com/%s", timeout, p.String())
+
+	deadline := time.Now().Add(timeout)
+	for {
+		builds, err := listWebhookBuilds(client, p, since)
+		if err != nil {
+			return webhookBuild{}, err
+		}
+		if len(builds) > 0 {
+			return builds[0], nil
+		}
+		if time.Now().After(deadline) {
+			return webhookBuild{}, fmt.Errorf("No webhook triggered build within %v", timeout)
+		}
+		time.Sleep(15 * time.Second)
+	}
+}
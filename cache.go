@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// pipelineCache keeps pipeline listings on disk for a while, so that repeated runs during
+// a change window don't list the whole organization every time, along with the last
+// inventory for planning offline. Both include webhook urls, so cache files are only
+// readable by the current user.
+type pipelineCache struct {
+	dir     string
+	ttl     time.Duration
+	refresh bool
+}
+
+type cachedPipelines struct {
+	FetchedAt time.Time  `json:"fetched_at"`
+	Pipelines []pipeline `json:"pipelines"`
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "github-webhook-rotate")
+}
+
+func (c *pipelineCache) path(org string, filter pipelineFilter) string {
+	b, _ := json.Marshal(filter)
+	sum := sha256.Sum256(b)
+	return filepath.Join(c.dir, fmt.Sprintf("pipelines-%s-%s.json", org, hex.EncodeToString(sum[:8])))
+}
+
+// wrap returns a lister that uses cached listings younger than the ttl
+func (c *pipelineCache) wrap(listPipelines pipelineLister) pipelineLister {
+	return func(org string, filter pipelineFilter) ([]pipeline, error) {
+		path := c.path(org, filter)
+
+		if !c.refresh {
+			if b, err := ioutil.ReadFile(path); err == nil {
+				var cached cachedPipelines
+				if err := json.Unmarshal(b, &cached); err == nil && time.Since(cached.FetchedAt) < c.ttl {
+					log.Printf("Using pipelines cached %v ago, use --refresh to list them again",
+						time.Since(cached.FetchedAt).Round(time.Second))
+					return cached.Pipelines, nil
+				}
+			}
+		}
+
+		pipelines, err := listPipelines(org, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(cachedPipelines{FetchedAt: time.Now().UTC(), Pipelines: pipelines})
+		if err == nil {
+			if err = os.MkdirAll(c.dir, 0700); err == nil {
+				err = ioutil.WriteFile(path, b, 0600)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to cache pipelines: %v", err)
+		}
+		return pipelines, nil
+	}
+}
+
+// cachedInventory is the state discovered by the last run, for planning offline
+type cachedInventory struct {
+	FetchedAt       time.Time                 `json:"fetched_at"`
+	Organization    string                    `json:"organization"`
+	Pipelines       []pipeline                `json:"pipelines"`
+	RepositoryHooks map[string][]*github.Hook `json:"repository_hooks"`
+}
+
+func (c *pipelineCache) inventoryPath(org string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("inventory-%s.json", org))
+}
+
+func (c *pipelineCache) saveInventory(inv *inventory) error {
+	// unparseable hooks are kept too, so they're reported again when the inventory is loaded
+	repoHooks := map[string][]*github.Hook{}
+	for repo, hooks := range inv.RepositoryHooks {
+		repoHooks[repo] = append([]*github.Hook{}, hooks...)
+	}
+	for _, hook := range inv.UnparseableHooks {
+		repoHooks[hook.githubRepository.String()] = append(repoHooks[hook.githubRepository.String()], hook.Hook)
+	}
+
+	b, err := json.Marshal(cachedInventory{
+		FetchedAt:       time.Now().UTC(),
+		Organization:    inv.Org,
+		Pipelines:       inv.Pipelines,
+		RepositoryHooks: repoHooks,
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.inventoryPath(inv.Org), b, 0600)
+}
+
+// loadInventory rebuilds the inventory from the last run, narrowed down by the filter
+func (c *pipelineCache) loadInventory(org string, filter pipelineFilter) (*inventory, time.Time, error) {
+	b, err := ioutil.ReadFile(c.inventoryPath(org))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var cached cachedInventory
+	if err := json.Unmarshal(b, &cached); err != nil {
+		return nil, time.Time{}, fmt.Errorf("Failed to parse cached inventory: %v", err)
+	}
+
+	var pipelines []pipeline
+	for _, p := range cached.Pipelines {
+		if filter.matches(p.Slug, p.Cluster, p.ClusterUUID, p.Tags) && (filter.ID == "" || p.ID == filter.ID) {
+			pipelines = append(pipelines, p)
+		}
+	}
+
+	return newInventory(cached.Organization, pipelines, cached.RepositoryHooks), cached.FetchedAt, nil
+}
+
+// invalidate removes cached listings and inventories for an organization, or each of several,
+// which are stale once webhooks have been rotated
+func (c *pipelineCache) invalidate(org string) {
+	var paths []string
+	for _, o := range splitOrganizations(org) {
+		listings, _ := filepath.Glob(filepath.Join(c.dir, fmt.Sprintf("pipelines-%s-*.json", o)))
+		paths = append(paths, listings...)
+	}
+	paths = append(paths, c.inventoryPath(org))
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove cached pipelines %s: %v", path, err)
+		}
+	}
+}
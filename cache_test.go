@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadInventoryFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := &pipelineCache{dir: dir}
+	err = cache.saveInventory(newInventory("acme", []pipeline{
+		{ID: "web-id", Org: "acme", Slug: "web", Cluster: "Default", ClusterUUID: "1b5f3e2c"},
+		{ID: "api-id", Org: "acme", Slug: "api", Cluster: "Deploys", ClusterUUID: "9d4a7c1e", Tags: []string{"production"}},
+		{ID: "docs-id", Org: "acme", Slug: "docs"},
+	}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter pipelineFilter
+		want   []string
+	}{
+		{"no filter", pipelineFilter{}, []string{"web", "api", "docs"}},
+		{"pipeline id", pipelineFilter{ID: "api-id"}, []string{"api"}},
+		{"unknown pipeline id", pipelineFilter{ID: "other-id"}, nil},
+		{"slug", pipelineFilter{Slug: "docs"}, []string{"docs"}},
+		{"slug pattern", pipelineFilter{Slug: "*e*"}, []string{"web"}},
+		{"cluster name", pipelineFilter{Cluster: "deploys"}, []string{"api"}},
+		{"cluster uuid", pipelineFilter{Cluster: "1b5f3e2c"}, []string{"web"}},
+		{"tag", pipelineFilter{Tags: []string{"Production"}}, []string{"api"}},
+		{"pipeline id and slug", pipelineFilter{ID: "api-id", Slug: "web"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inv, _, err := cache.loadInventory("acme", tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range inv.Pipelines {
+				got = append(got, p.Slug)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lastRunPath is where the fingerprint of the inventory seen by the last run is kept. The
+// filter is part of the name so runs against a few pipelines don't look like everything
+// else was removed.
+func (c *pipelineCache) lastRunPath(org string, filter pipelineFilter) string {
+	b, _ := json.Marshal(filter)
+	sum := sha256.Sum256(b)
+	return filepath.Join(c.dir, fmt.Sprintf("last-run-%s-%s.json", org, hex.EncodeToString(sum[:8])))
+}
+
+// loadLastRun reads the fingerprint saved by the last run, if there was one
+func (c *pipelineCache) loadLastRun(org string, filter pipelineFilter) (*inventoryExport, error) {
+	export, err := readInventory(c.lastRunPath(org, filter))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return export, err
+}
+
+// saveLastRun writes the fingerprint of an inventory as it was left at the end of a run, with
+// the rotated webhooks and the hooks that were updated to them. Webhook tokens are masked,
+// so it doesn't need to be kept secret.
+func (c *pipelineCache) saveLastRun(inv *inventory, filter pipelineFilter, rotations []rotation, report *runReport) error {
+	records := inv.records()
+	for _, rotation := range rotations {
+		token, err := getWebhookToken(rotation.NewWebhookURL)
+		if err != nil {
+			return err
+		}
+		for i, record := range records {
+			if record.PipelineID != rotation.Pipeline.ID {
+				continue
+			}
+			records[i].WebhookToken = maskToken(token)
+			if record.HookID != 0 && report != nil && report.updated(record.Repository, record.HookID) {
+				records[i].HookURL = maskWebhookURL(rotation.NewWebhookURL)
+			}
+		}
+	}
+
+	b, err := json.Marshal(inventoryExport{
+		Organization: inv.Org,
+		GeneratedAt:  time.Now().UTC(),
+		Records:      records,
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.lastRunPath(inv.Org, filter), b, 0600)
+}
+
+// printChangelog shows what has changed since the last run, so changes made by someone
+// else in the meantime don't go unnoticed
+func printChangelog(w io.Writer, last *inventoryExport, inv *inventory) {
+	diff := diffInventories(last.Records, inv.records())
+	if diff.empty() {
+		fmt.Fprintf(w, "No changes since the last run at %s\n\n", last.GeneratedAt.Format(time.RFC3339))
+		return
+	}
+	fmt.Fprintf(w, "Since the last run at %s:\n", last.GeneratedAt.Format(time.RFC3339))
+	printInventoryDiff(w, diff)
+	fmt.Fprintln(w)
+}
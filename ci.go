@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// buildkiteMetaDataPrefix namespaces the build meta-data keys written when running in a job
+const buildkiteMetaDataPrefix = "github-webhook-rotate"
+
+// summary is the outcome of a run as flat key values, for ci systems to branch on
+func (r *runReport) summary() map[string]string {
+	counts := map[string]int{}
+	var failed []string
+	for _, result := range r.Pipelines {
+		counts[result.Outcome]++
+		if result.Outcome == outcomeFailed || result.Outcome == outcomePartial {
+			failed = append(failed, result.Pipeline)
+		}
+	}
+	return map[string]string{
+		"rotated":          fmt.Sprintf("%d", counts[outcomeRotated]),
+		"partial":          fmt.Sprintf("%d", counts[outcomePartial]),
+		"failed":           fmt.Sprintf("%d", counts[outcomeFailed]),
+		"skipped":          fmt.Sprintf("%d", counts[outcomeSkipped]),
+		"failed-pipelines": strings.Join(failed, ","),
+		"exit-code":        fmt.Sprintf("%d", r.exitCode()),
+	}
+}
+
+// inBuildkiteJob is whether this is running in a buildkite job that can set build meta-data
+func inBuildkiteJob() bool {
+	if os.Getenv("BUILDKITE") != "true" || os.Getenv("BUILDKITE_AGENT_ACCESS_TOKEN") == "" {
+		return false
+	}
+	_, err := exec.LookPath("buildkite-agent")
+	return err == nil
+}
+
+// setBuildkiteMetaData writes the summary of a run to build meta-data, so later steps in the
+// build can branch on it with buildkite-agent meta-data get
+func setBuildkiteMetaData(report *runReport) error {
+	for key, value := range report.summary() {
+		// meta-data values can't be empty
+		if value == "" {
+			value = "none"
+		}
+		key = buildkiteMetaDataPrefix + ":" + key
+		out, err := exec.Command("buildkite-agent", "meta-data", "set", key, value).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to set meta-data %s: %v %s", key, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// writeGithubActions writes the summary of a run as step outputs and a job summary, and
+// annotates the pipelines that failed
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+func writeGithubActions(w io.Writer, report *runReport) error {
+	for _, result := range report.Pipelines {
+		if result.Outcome == outcomeFailed || result.Outcome == outcomePartial {
+			fmt.Fprintf(w, "::error title=Webhook rotation %s for %s::%s\n", result.Outcome, result.Pipeline,
+				escapeWorkflowCommand(firstNonEmpty(result.Reason, fmt.Sprintf("%d hooks need a manual fix", result.failedHooks()))))
+		}
+	}
+
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		summary := report.summary()
+		var keys []string
+		for key := range summary {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var outputs strings.Builder
+		for _, key := range keys {
+			fmt.Fprintf(&outputs, "%s=%s\n", key, summary[key])
+		}
+		if err := appendFile(path, outputs.String()); err != nil {
+			return fmt.Errorf("Failed to write step outputs: %v", err)
+		}
+	}
+
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		var md strings.Builder
+		fmt.Fprintf(&md, "### Webhook rotation for %s\n\n", report.Organization)
+		fmt.Fprintf(&md, "| Pipeline | Outcome | Hooks updated | Reason |\n")
+		fmt.Fprintf(&md, "| -------- | ------- | ------------- | ------ |\n")
+		for _, result := range report.Pipelines {
+			fmt.Fprintf(&md, "| [%s](https://buildkite.com/%s) | %s | %d of %d | %s |\n", result.Pipeline, result.Pipeline,
+				result.Outcome, len(result.Hooks)-result.failedHooks(), len(result.Hooks), strings.Replace(result.Reason, "|", "\\|", -1))
+		}
+		if err := appendFile(path, md.String()); err != nil {
+			return fmt.Errorf("Failed to write job summary: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// escapeWorkflowCommand escapes the data of a workflow command, so multi-line errors stay intact
+func escapeWorkflowCommand(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.WriteString(f, content)
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// boundedGroup is an errgroup that runs at most limit functions at a time. The first error
+// cancels the group's context and is returned by wait.
+type boundedGroup struct {
+	g   *errgroup.Group
+	ctx context.Context
+	sem chan struct{}
+}
+
+func newBoundedGroup(ctx context.Context, limit int) *boundedGroup {
+	g, gctx := errgroup.WithContext(ctx)
+	return &boundedGroup{g: g, ctx: gctx, sem: make(chan struct{}, limit)}
+}
+
+// do runs f once there's room, or not at all if the group's context is done first
+func (b *boundedGroup) do(f func(ctx context.Context) error) {
+	b.g.Go(func() error {
+		select {
+		case b.sem <- struct{}{}:
+			defer func() { <-b.sem }()
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		}
+		if err := b.ctx.Err(); err != nil {
+			return err
+		}
+		return f(b.ctx)
+	})
+}
+
+func (b *boundedGroup) wait() error {
+	return b.g.Wait()
+}
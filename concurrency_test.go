@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBoundedGroupLimit(t *testing.T) {
+	g := newBoundedGroup(context.Background(), 3)
+	var mu sync.Mutex
+	running, most := 0, 0
+	for i := 0; i < 20; i++ {
+		g.do(func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if most != 3 {
+		t.Fatalf("Expected at most 3 to run at a time, got %d", most)
+	}
+}
+
+func TestBoundedGroupFirstError(t *testing.T) {
+	g := newBoundedGroup(context.Background(), 2)
+	failed := errors.New("Failed")
+	ran := 0
+	g.do(func(ctx context.Context) error {
+		ran++
+		return failed
+	})
+
+	<-g.ctx.Done()
+	for i := 0; i < 5; i++ {
+		g.do(func(ctx context.Context) error {
+			ran++
+			return nil
+		})
+	}
+	if err := g.wait(); err != failed {
+		t.Fatalf("Expected the first error, got %v", err)
+	}
+	if ran != 1 {
+		t.Fatalf("Expected functions queued after the error not to run, %d ran", ran)
+	}
+}
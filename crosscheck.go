@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/buildkite/cli/graphql"
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// buildkiteAuditEvent is an organization audit event for a rotated pipeline webhook
+type buildkiteAuditEvent struct {
+	UUID       string
+	OccurredAt time.Time
+	PipelineID string
+}
+
+func listWebhookRotationAuditEvents(client *graphql.Client, org string, since time.Time) ([]buildkiteAuditEvent, error) {
+	resp, err := client.Do(`
+	query WebhookRotationAuditEvents($org: ID!, $since: DateTime) {
+		organization(slug: $org) {
+			auditEvents(first: 500, occurredAtFrom: $since, type: PIPELINE_WEBHOOK_URL_ROTATED) {
+				edges {
+					node {
+						uuid
+						occurredAt
+						subject {
+							node {
+								... on Pipeline {
+									id
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	`, map[string]interface{}{
+		`org`:   org,
+		`since`: since.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedResp struct {
+		Data struct {
+			Organization struct {
+				AuditEvents struct {
+					Edges []struct {
+						Node struct {
+							UUID       string    `json:"uuid"`
+							OccurredAt time.Time `json:"occurredAt"`
+							Subject    struct {
+								Node struct {
+									ID string `json:"id"`
+								} `json:"node"`
+							} `json:"subject"`
+						} `json:"node"`
+					} `json:"edges"`
+				} `json:"auditEvents"`
+			} `json:"organization"`
+		} `json:"data"`
+	}
+
+	if err = resp.DecodeInto(&parsedResp); err != nil {
+		return nil, fmt.Errorf("Failed to parse GraphQL response: %v", err)
+	}
+
+	var events []buildkiteAuditEvent
+	for _, edge := range parsedResp.Data.Organization.AuditEvents.Edges {
+		events = append(events, buildkiteAuditEvent{
+			UUID:       edge.Node.UUID,
+			OccurredAt: edge.Node.OccurredAt,
+			PipelineID: edge.Node.Subject.Node.ID,
+		})
+	}
+	return events, nil
+}
+
+// crossCheckBuildkiteAuditEvents confirms that buildkite recorded an audit event for every
+// rotated pipeline, and adds the audit event ids to the report. Audit events can take a
+// little while to show up, so this tries a few times.
+func crossCheckBuildkiteAuditEvents(client *graphql.Client, report *runReport) error {
+	for attempt := 1; ; attempt++ {
+		var events []buildkiteAuditEvent
+		for _, org := range splitOrganizations(report.Organization) {
+			orgEvents, err := listWebhookRotationAuditEvents(client, org, report.StartedAt)
+			if err != nil {
+				return err
+			}
+			events = append(events, orgEvents...)
+		}
+
+		missing := 0
+		for i, result := range report.Pipelines {
+			if result.Outcome != outcomeRotated && result.Outcome != outcomePartial {
+				continue
+			}
+			for _, event := range events {
+				if event.PipelineID == result.PipelineID {
+					report.Pipelines[i].BuildkiteAuditEvent = event.UUID
+				}
+			}
+			if report.Pipelines[i].BuildkiteAuditEvent == "" {
+				missing++
+			}
+		}
+
+		if missing == 0 {
+			log.Printf("Confirmed rotations in the Buildkite audit log")
+			return nil
+		} else if attempt == 3 {
+			for _, result := range report.Pipelines {
+				if (result.Outcome == outcomeRotated || result.Outcome == outcomePartial) && result.BuildkiteAuditEvent == "" {
+					log.Printf(color.YellowString("⚠️  No Buildkite audit event found for rotating https://buildkite.com/%s",
+						result.Pipeline))
+				}
+			}
+			return nil
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// githubAuditEvent is a hook config change in a github organization's audit log
+// https://docs.github.com/en/enterprise-cloud@latest/rest/orgs/orgs#get-the-audit-log-for-an-organization
+type githubAuditEvent struct {
+	DocumentID string `json:"_document_id"`
+	Action     string `json:"action"`
+	HookID     int64  `json:"hook_id"`
+	Repo       string `json:"repo"`
+	Timestamp  int64  `json:"@timestamp"`
+}
+
+func listHookConfigChangedEvents(ctx context.Context, client *github.Client, owner string, since time.Time) ([]githubAuditEvent, error) {
+	phrase := fmt.Sprintf("action:hook.config_changed created:>=%s", since.UTC().Format("2006-01-02"))
+	u := fmt.Sprintf("orgs/%s/audit-log?phrase=%s&per_page=100", owner, url.QueryEscape(phrase))
+
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []githubAuditEvent
+	if _, err = client.Do(ctx, req, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// crossCheckGithubAuditEvents confirms that github recorded a config change for every updated
+// hook and adds the audit event ids to the report. The audit log api is only available to
+// organizations on GitHub Enterprise Cloud.
+func crossCheckGithubAuditEvents(ctx context.Context, ghClients *githubClients, report *runReport) error {
+	eventsByOwner := map[string][]githubAuditEvent{}
+
+	for i, result := range report.Pipelines {
+		for j, hook := range result.Hooks {
+			if !hook.Updated {
+				continue
+			}
+
+			repo, err := parseRepositoryName(hook.Repository)
+			if err != nil {
+				return err
+			}
+
+			owner := repo.owner()
+			events, ok := eventsByOwner[owner]
+			if !ok {
+				client, err := ghClients.clientFor(repo)
+				if err != nil {
+					return err
+				}
+				if events, err = listHookConfigChangedEvents(ctx, client, repo.Org, report.StartedAt); err != nil {
+					return fmt.Errorf("Error reading the audit log for %s: %v", repo.Org, err)
+				}
+				eventsByOwner[owner] = events
+			}
+
+			for _, event := range events {
+				if event.HookID == hook.ID && time.Unix(0, event.Timestamp*int64(time.Millisecond)).After(report.StartedAt) {
+					report.Pipelines[i].Hooks[j].GithubAuditEvent = event.DocumentID
+				}
+			}
+
+			if report.Pipelines[i].Hooks[j].GithubAuditEvent == "" {
+				log.Printf(color.YellowString("⚠️  No GitHub audit event found for updating %s/settings/hooks/%d",
+					githubURL(hook.Repository), hook.ID))
+			}
+		}
+	}
+
+	if len(eventsByOwner) > 0 {
+		log.Printf("Cross-checked hook updates against the GitHub audit log")
+	}
+	return nil
+}
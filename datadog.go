@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// datadogPublisher posts an event to the datadog event stream for each rotation and each
+// hook that couldn't be updated, so webhook changes show up on dashboards next to deploys
+//
+// https://docs.datadoghq.com/api/latest/events/#post-an-event
+type datadogPublisher struct {
+	apiKey string
+	site   string
+}
+
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	Tags           []string `json:"tags"`
+	AlertType      string   `json:"alert_type"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+}
+
+func (d *datadogPublisher) publish(ctx context.Context, org string, p pipeline, result pipelineResult) error {
+	tags := []string{"org:" + org, "pipeline:" + p.Slug, "repo:" + p.Repository.String()}
+
+	event := datadogEvent{
+		Title:          fmt.Sprintf("Rotated Buildkite webhook for %s", result.Pipeline),
+		Text:           fmt.Sprintf("https://buildkite.com/%s", result.Pipeline),
+		Tags:           tags,
+		AlertType:      "success",
+		AggregationKey: result.PipelineID,
+		SourceTypeName: "buildkite",
+	}
+	switch result.Outcome {
+	case outcomePartial:
+		event.AlertType = "warning"
+		event.Text += fmt.Sprintf("\n%d hooks need a manual fix", result.failedHooks())
+	case outcomeFailed:
+		event.Title = fmt.Sprintf("Failed to rotate Buildkite webhook for %s", result.Pipeline)
+		event.Text += "\n" + result.Reason
+		event.AlertType = "error"
+	}
+	if err := d.post(ctx, event); err != nil {
+		return err
+	}
+
+	// each hook left pointing at the revoked webhook is its own failure
+	if result.Outcome != outcomePartial {
+		return nil
+	}
+	for _, hook := range result.Hooks {
+		if hook.Updated {
+			continue
+		}
+		err := d.post(ctx, datadogEvent{
+			Title:          fmt.Sprintf("Failed to update %s hook for %s", hook.Repository, result.Pipeline),
+			Text:           fmt.Sprintf("%s/settings/hooks/%d\n%s", githubURL(hook.Repository), hook.ID, hook.Error),
+			Tags:           []string{"org:" + org, "pipeline:" + p.Slug, "repo:" + hook.Repository},
+			AlertType:      "error",
+			AggregationKey: result.PipelineID,
+			SourceTypeName: "buildkite",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *datadogPublisher) post(ctx context.Context, event datadogEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://api.%s/api/v1/events", strings.TrimPrefix(d.site, "api.")), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", d.apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Datadog responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// hookDeliveries are the most recent deliveries of repository hooks, keyed by repository and hook id
+type hookDeliveries map[string]hookDelivery
+
+func hookDeliveryKey(repo githubRepository, hookID int64) string {
+	return fmt.Sprintf("%s|%d", repo.String(), hookID)
+}
+
+func (d hookDeliveries) last(repo githubRepository, hookID int64) (hookDelivery, bool) {
+	delivery, ok := d[hookDeliveryKey(repo, hookID)]
+	return delivery, ok
+}
+
+func (d hookDelivery) failed() bool {
+	return d.StatusCode < 200 || d.StatusCode > 299
+}
+
+// failing is whether the last delivery to any of a pipeline's hooks failed
+func (d hookDeliveries) failing(matches []githubRepositoryHook) bool {
+	for _, match := range matches {
+		if delivery, ok := d.last(match.githubRepository, *match.Hook.ID); ok && delivery.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// prioritizeFailing moves pipelines with hooks whose last delivery failed to the front, so
+// webhooks that are already broken are looked at first. It returns how many are failing.
+func prioritizeFailing(inv *inventory) int {
+	failing := 0
+	for _, p := range inv.Pipelines {
+		if inv.LastDeliveries.failing(inv.TokenHooks[p.WebhookToken]) {
+			failing++
+		}
+	}
+	sort.SliceStable(inv.Pipelines, func(i, j int) bool {
+		return inv.LastDeliveries.failing(inv.TokenHooks[inv.Pipelines[i].WebhookToken]) &&
+			!inv.LastDeliveries.failing(inv.TokenHooks[inv.Pipelines[j].WebhookToken])
+	})
+	return failing
+}
+
+func lastHookDelivery(ctx context.Context, client *github.Client, repo githubRepository, hookID int64) (*hookDelivery, error) {
+	u := fmt.Sprintf("repos/%s/%s/hooks/%d/deliveries?per_page=1", repo.Org, repo.Name, hookID)
+
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []hookDelivery
+	if _, err = client.Do(ctx, req, &deliveries); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	return &deliveries[0], nil
+}
+
+// fetchLastDeliveries looks up the most recent delivery of every buildkite hook in an inventory.
+// Hooks whose deliveries can't be read are warned about and left out.
+func fetchLastDeliveries(ctx context.Context, ghClients *githubClients, inv *inventory, concurrency int) hookDeliveries {
+	deliveries := hookDeliveries{}
+	var mu sync.Mutex
+	g := newBoundedGroup(ctx, concurrency)
+
+	for _, repo := range inv.repositories() {
+		for _, hook := range inv.RepositoryHooks[repo.String()] {
+			repo, hookID := repo, *hook.ID
+			g.do(func(ctx context.Context) error {
+				client, err := ghClients.clientFor(repo)
+				var delivery *hookDelivery
+				if err == nil {
+					delivery, err = lastHookDelivery(ctx, client, repo, hookID)
+				}
+				if err != nil {
+					log.Printf(color.YellowString("⚠️  Failed to read deliveries of %s/settings/hooks/%d: %v",
+						repo.URL(), hookID, err))
+					return nil
+				} else if delivery == nil {
+					return nil
+				}
+
+				mu.Lock()
+				deliveries[hookDeliveryKey(repo, hookID)] = *delivery
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+
+	g.wait()
+	return deliveries
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// etagTransport caches github GET responses on disk and revalidates them with If-None-Match,
+// since 304 responses don't count against the rate limit. Responses are keyed by the token
+// as well as the url, so that one token never sees what was fetched with another.
+type etagTransport struct {
+	base  http.RoundTripper
+	dir   string
+	token string
+}
+
+func (t *etagTransport) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(t.token + "\n" + req.URL.String()))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:]))
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	path := t.path(req)
+	cached := t.read(path, req)
+	if cached != nil {
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			// round trippers shouldn't modify the request they're given
+			conditional := *req
+			conditional.Header = http.Header{}
+			for k, v := range req.Header {
+				conditional.Header[k] = v
+			}
+			conditional.Header.Set("If-None-Match", etag)
+			req = &conditional
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		// keep the fresh rate limit headers
+		for k, v := range resp.Header {
+			if strings.HasPrefix(k, "X-Ratelimit") {
+				cached.Header[k] = v
+			}
+		}
+		return cached, nil
+	}
+
+	if resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "" {
+		if dump, err := httputil.DumpResponse(resp, true); err == nil {
+			if err := os.MkdirAll(t.dir, 0700); err == nil {
+				ioutil.WriteFile(path, dump, 0600)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (t *etagTransport) read(path string, req *http.Request) *http.Response {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+const (
+	eventSource     = "buildkite.github-webhook-rotate"
+	eventDetailType = "Buildkite Webhook Rotation"
+)
+
+// eventBridgePublisher publishes an event per rotation so downstream automation can react to it
+type eventBridgePublisher struct {
+	bus    string
+	client *eventbridge.EventBridge
+}
+
+type rotationEvent struct {
+	Organization string       `json:"organization"`
+	Pipeline     string       `json:"pipeline"`
+	PipelineID   string       `json:"pipeline_id"`
+	Outcome      string       `json:"outcome"`
+	Reason       string       `json:"reason,omitempty"`
+	Hooks        []hookResult `json:"hooks"`
+}
+
+func newEventBridgePublisher(bus string) (*eventBridgePublisher, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &eventBridgePublisher{bus: bus, client: eventbridge.New(sess)}, nil
+}
+
+func (e *eventBridgePublisher) publish(ctx context.Context, org string, result pipelineResult) error {
+	hooks := result.Hooks
+	if hooks == nil {
+		hooks = []hookResult{}
+	}
+
+	detail, err := json.Marshal(rotationEvent{
+		Organization: org,
+		Pipeline:     result.Pipeline,
+		PipelineID:   result.PipelineID,
+		Outcome:      result.Outcome,
+		Reason:       result.Reason,
+		Hooks:        hooks,
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := e.client.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(e.bus),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(eventDetailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// failures for individual entries are reported in the response
+	if aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		return fmt.Errorf("%s: %s", aws.StringValue(out.Entries[0].ErrorCode), aws.StringValue(out.Entries[0].ErrorMessage))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// exit codes, so that wrapping automation can branch on the outcome of a run
+const (
+	// exitError is for anything else that stops a run, like bad flags or api errors
+	exitError = 1
+
+	// exitAuth is when buildkite or github rejected a token
+	exitAuth = 3
+
+	// exitPartial is when some rotations or hook updates failed
+	exitPartial = 4
+
+	// exitDrift is when reconcile found differences from the inventory
+	exitDrift = 5
+
+	// exitNothingToDo is when a run finished without rotating anything
+	exitNothingToDo = 6
+)
+
+// authTransport notes whether any request was rejected for its credentials, so that
+// errors anywhere in a run can be reported with exitAuth
+type authTransport struct {
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	rejected bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.mu.Lock()
+		t.rejected = true
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (t *authTransport) authFailed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejected
+}
+
+// the graphql and github clients all build on the default client's transport
+var authFailures = &authTransport{base: http.DefaultTransport}
+
+func init() {
+	http.DefaultClient.Transport = authFailures
+}
+
+// fatalf logs an error and exits, with exitAuth if a token was rejected along the way
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	if authFailures.authFailed() {
+		os.Exit(exitAuth)
+	}
+	os.Exit(exitError)
+}
+
+// exitCode is the outcome of a rotation run
+func (r *runReport) exitCode() int {
+	rotated := 0
+	for _, result := range r.Pipelines {
+		switch result.Outcome {
+		case outcomeFailed, outcomePartial:
+			return exitPartial
+		case outcomeRotated:
+			rotated++
+		}
+	}
+	if rotated == 0 {
+		return exitNothingToDo
+	}
+	return 0
+}
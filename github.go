@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v25/github"
+	"golang.org/x/oauth2"
+)
+
+const defaultGithubHost = "github.com"
+
+// githubClients has clients for each github host that repositories live on, so pipelines
+// backed by github.com and github enterprise server can be rotated in the same run. Owners
+// and repositories can have their own tokens, for when no single token can edit hooks
+// across all of them.
+type githubClients struct {
+	ctx context.Context
+
+	// hostTokens are the default token for each host
+	hostTokens map[string]string
+
+	// ownerTokens are keyed by owner or repository, in the form of githubRepository.String()
+	ownerTokens map[string]string
+
+	// app is used for github.com repositories that its installations can access
+	app *githubApp
+
+	// clients are keyed by host and token, or installation, and are looked up concurrently
+	// while discovering hooks
+	clients   map[string]*github.Client
+	clientsMu sync.Mutex
+
+	// etagDir caches responses for conditional requests if it's set
+	etagDir string
+
+	// apiURLs are the api base urls of hosts that don't serve it at https://host/api/v3/
+	apiURLs map[string]string
+}
+
+// newGithubClients sets up github.com with the default token, and other hosts from
+// host=token pairs
+func newGithubClients(ctx context.Context, token string, hostTokens []string) (*githubClients, error) {
+	c := &githubClients{
+		ctx:         ctx,
+		hostTokens:  map[string]string{defaultGithubHost: token},
+		ownerTokens: map[string]string{},
+		clients:     map[string]*github.Client{},
+	}
+	for _, hostToken := range hostTokens {
+		parts := strings.SplitN(hostToken, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Expected a github host like host=token, got %q", hostToken)
+		}
+		c.hostTokens[strings.ToLower(parts[0])] = parts[1]
+	}
+	return c, nil
+}
+
+// withDefaultToken returns clients that use a different token for github.com, such as a
+// read-only one, with the same tokens for everything else
+func (c *githubClients) withDefaultToken(token string) *githubClients {
+	hostTokens := map[string]string{}
+	for host, hostToken := range c.hostTokens {
+		hostTokens[host] = hostToken
+	}
+	hostTokens[defaultGithubHost] = token
+	return &githubClients{
+		ctx:         c.ctx,
+		hostTokens:  hostTokens,
+		ownerTokens: c.ownerTokens,
+		app:         c.app,
+		clients:     map[string]*github.Client{},
+		etagDir:     c.etagDir,
+		apiURLs:     c.apiURLs,
+	}
+}
+
+// addAPIURLs sets the api base urls of hosts given like host=url, for github enterprise
+// servers behind a different host or port than their remotes use
+func (c *githubClients) addAPIURLs(hostURLs []string) error {
+	if c.apiURLs == nil {
+		c.apiURLs = map[string]string{}
+	}
+	for _, hostURL := range hostURLs {
+		parts := strings.SplitN(hostURL, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Expected a github api url like host=url, got %q", hostURL)
+		}
+		u, err := url.Parse(parts[1])
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Expected an absolute api url for %s, got %q", parts[0], parts[1])
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		c.apiURLs[strings.ToLower(parts[0])] = u.String()
+	}
+	return nil
+}
+
+// readOwnerTokens reads a json file mapping owners or repositories (like my-org, my-org/repo
+// or github.example.com/my-org) to the tokens to use for them
+func (c *githubClients) readOwnerTokens(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return fmt.Errorf("Failed to parse github tokens %s: %v", path, err)
+	}
+	for owner, token := range tokens {
+		c.ownerTokens[strings.ToLower(owner)] = token
+	}
+	return nil
+}
+
+// client returns the client for a host's default token
+func (c *githubClients) client(host string) (*github.Client, error) {
+	if host == "" {
+		host = defaultGithubHost
+	}
+	token, ok := c.hostTokens[host]
+	if !ok {
+		return nil, fmt.Errorf("No token for %s, provide one with --github-host %s=TOKEN", host, host)
+	}
+	return c.clientWithToken(host, token)
+}
+
+// clientFor returns the client for a repository, using the most specific token that's
+// been mapped to it, falling back to the default token for its host
+func (c *githubClients) clientFor(repo githubRepository) (*github.Client, error) {
+	for _, key := range []string{repo.String(), repo.owner()} {
+		if token, ok := c.ownerTokens[strings.ToLower(key)]; ok {
+			return c.clientWithToken(repo.Host, token)
+		}
+	}
+	if c.app != nil && (repo.Host == "" || repo.Host == defaultGithubHost) {
+		if installation, ok := c.app.installations[strings.ToLower(repo.String())]; ok {
+			key := fmt.Sprintf("installation|%d", installation)
+			c.clientsMu.Lock()
+			defer c.clientsMu.Unlock()
+			if _, ok := c.clients[key]; !ok {
+				c.clients[key] = c.app.installationClient(c.ctx, installation)
+			}
+			return c.clients[key], nil
+		}
+	}
+	return c.client(repo.Host)
+}
+
+// isFineGrainedToken is whether a token is a fine-grained personal access token, which have
+// per repository permissions rather than classic scopes like admin:repo_hook
+func isFineGrainedToken(token string) bool {
+	return strings.HasPrefix(token, "github_pat_")
+}
+
+// usesFineGrainedToken is whether a repository is accessed with a fine-grained token, following
+// the same order as clientFor
+func (c *githubClients) usesFineGrainedToken(repo githubRepository) bool {
+	for _, key := range []string{repo.String(), repo.owner()} {
+		if token, ok := c.ownerTokens[strings.ToLower(key)]; ok {
+			return isFineGrainedToken(token)
+		}
+	}
+	if c.app != nil && (repo.Host == "" || repo.Host == defaultGithubHost) {
+		if _, ok := c.app.installations[strings.ToLower(repo.String())]; ok {
+			return false
+		}
+	}
+	host := repo.Host
+	if host == "" {
+		host = defaultGithubHost
+	}
+	return isFineGrainedToken(c.hostTokens[host])
+}
+
+// checkWebhookPermissions finds the repositories that a fine-grained token can't edit hooks in,
+// by updating a hook in each to its current url. Classic tokens are covered by the permission
+// test that runs before each rotation.
+func (c *githubClients) checkWebhookPermissions(ctx context.Context, pipelines []pipeline, tokenHooks map[string][]githubRepositoryHook) map[string]string {
+	gaps := map[string]string{}
+	checked := map[string]bool{}
+
+	for _, pipeline := range pipelines {
+		for _, match := range tokenHooks[pipeline.WebhookToken] {
+			repo := match.githubRepository
+			if checked[repo.String()] || !c.usesFineGrainedToken(repo) {
+				continue
+			}
+			checked[repo.String()] = true
+
+			client, err := c.clientFor(repo)
+			if err == nil {
+				err = updateGithubRepositoryHook(ctx, client, match, hookURL(match.Hook), hookFixes{})
+			}
+			if err != nil {
+				gaps[repo.String()] = err.Error()
+
+				// github says which permissions the request needed
+				if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil {
+					if accepted := errResp.Response.Header.Get("X-Accepted-GitHub-Permissions"); accepted != "" {
+						gaps[repo.String()] = fmt.Sprintf("needs %s", accepted)
+					}
+				}
+			}
+		}
+	}
+
+	if len(checked) > 0 {
+		log.Printf("Checked fine-grained token permissions for %d repositories", len(checked))
+	}
+	return gaps
+}
+
+// clientWithToken creates a client the first time it's needed
+func (c *githubClients) clientWithToken(host, token string) (*github.Client, error) {
+	if host == "" {
+		host = defaultGithubHost
+	}
+	key := host + "|" + token
+	c.clientsMu.Lock()
+	defer c.clientsMu.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	httpClient := oauth2.NewClient(c.ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	if c.etagDir != "" {
+		httpClient.Transport = &etagTransport{base: httpClient.Transport, dir: c.etagDir, token: host + "|" + token}
+	}
+
+	var client *github.Client
+	if apiURL, ok := c.apiURLs[host]; ok {
+		var err error
+		if client, err = github.NewEnterpriseClient(apiURL, apiURL, httpClient); err != nil {
+			return nil, err
+		}
+	} else if host == defaultGithubHost {
+		client = github.NewClient(httpClient)
+	} else {
+		var err error
+		client, err = github.NewEnterpriseClient(
+			fmt.Sprintf("https://%s/api/v3/", host), fmt.Sprintf("https://%s/api/uploads/", host), httpClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.clients[key] = client
+	return client, nil
+}
+
+// defaultClient is the github.com client, used for things that aren't tied to a repository
+func (c *githubClients) defaultClient() *github.Client {
+	client, _ := c.client(defaultGithubHost)
+	return client
+}
+
+// githubURL links to a repository given as org/name, or host/org/name for hosts other than github.com
+func githubURL(repo string) string {
+	if strings.Count(repo, "/") >= 2 {
+		return "https://" + repo
+	}
+	return "https://" + defaultGithubHost + "/" + repo
+}
+
+// parseRepositoryName is the reverse of githubRepository.String()
+func parseRepositoryName(repo string) (githubRepository, error) {
+	parts := strings.Split(repo, "/")
+	switch len(parts) {
+	case 2:
+		return githubRepository{Host: defaultGithubHost, Org: parts[0], Name: parts[1]}, nil
+	case 3:
+		return githubRepository{Host: parts[0], Org: parts[1], Name: parts[2]}, nil
+	}
+	return githubRepository{}, fmt.Errorf("Expected a repository like org/name or host/org/name, got %q", repo)
+}
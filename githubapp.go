@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+	"golang.org/x/oauth2"
+)
+
+// githubApp authenticates as a github app, and finds the installation that has access to
+// each repository so that hooks can be listed and edited with installation tokens
+type githubApp struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *github.Client
+
+	// installations are keyed by lowercased repository full name
+	installations map[string]int64
+}
+
+func newGithubApp(ctx context.Context, id int64, keyFile string) (*githubApp, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM encoded key found in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse github app key %s: %v", keyFile, err)
+	}
+
+	app := &githubApp{id: id, key: key, installations: map[string]int64{}}
+	app.client = github.NewClient(oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, appTokenSource{app})))
+	return app, nil
+}
+
+// jwt signs a short lived token that identifies the app
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]int64{
+		// allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type appTokenSource struct {
+	app *githubApp
+}
+
+func (s appTokenSource) Token() (*oauth2.Token, error) {
+	now := time.Now()
+	jwt, err := s.app.jwt(now)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: jwt, TokenType: "Bearer", Expiry: now.Add(8 * time.Minute)}, nil
+}
+
+// installationTokenSource mints installation tokens, which expire after an hour
+type installationTokenSource struct {
+	ctx          context.Context
+	app          *githubApp
+	installation int64
+}
+
+func (s installationTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := s.app.client.Apps.CreateInstallationToken(s.ctx, s.installation)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a token for installation %d: %v", s.installation, err)
+	}
+	return &oauth2.Token{AccessToken: token.GetToken(), TokenType: "token", Expiry: token.GetExpiresAt()}, nil
+}
+
+func (a *githubApp) installationClient(ctx context.Context, installation int64) *github.Client {
+	return github.NewClient(oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, installationTokenSource{ctx, a, installation})))
+}
+
+// discover enumerates the app's installations and the repositories each can access
+func (a *githubApp) discover(ctx context.Context) error {
+	var installations []*github.Installation
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := a.client.Apps.ListInstallations(ctx, opt)
+		if err != nil {
+			return fmt.Errorf("Failed to list github app installations: %v", err)
+		}
+		installations = append(installations, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	for _, installation := range installations {
+		client := a.installationClient(ctx, installation.GetID())
+
+		opt := &github.ListOptions{PerPage: 100}
+		for {
+			repos, resp, err := client.Apps.ListRepos(ctx, opt)
+			if err != nil {
+				return fmt.Errorf("Failed to list repositories for the installation on %s: %v",
+					installation.GetAccount().GetLogin(), err)
+			}
+			for _, repo := range repos {
+				a.installations[strings.ToLower(repo.GetFullName())] = installation.GetID()
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+
+	log.Printf("Found %d installations of the GitHub App with access to %d repositories",
+		len(installations), len(a.installations))
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// rotation is a pipeline webhook that was successfully rotated
+type rotation struct {
+	Pipeline      pipeline
+	OldWebhookURL string
+	NewWebhookURL string
+}
+
+// openGitopsPullRequest updates files in a config repository that refer to rotated webhook
+// urls and opens a pull request with the changes. Paths are templates that are rendered
+// with each rotated pipeline, e.g pipelines/{{.Org}}/{{.Slug}}.tf
+func openGitopsPullRequest(ctx context.Context, client *github.Client, repoSpec string, pathTemplates []string, rotations []rotation) (*github.PullRequest, error) {
+	repoParts := strings.SplitN(repoSpec, "/", 2)
+	if len(repoParts) != 2 {
+		return nil, fmt.Errorf("Expected a config repository like org/name, got %q", repoSpec)
+	}
+	owner, name := repoParts[0], repoParts[1]
+
+	// render the paths to update for every rotated pipeline
+	var paths []string
+	seen := map[string]bool{}
+	for _, pathTemplate := range pathTemplates {
+		tmpl, err := template.New("path").Parse(pathTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse path template %q: %v", pathTemplate, err)
+		}
+		for _, r := range rotations {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, r.Pipeline); err != nil {
+				return nil, fmt.Errorf("Failed to render path template %q: %v", pathTemplate, err)
+			}
+			if !seen[buf.String()] {
+				seen[buf.String()] = true
+				paths = append(paths, buf.String())
+			}
+		}
+	}
+
+	repo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+
+	base := repo.GetDefaultBranch()
+	baseRef, _, err := client.Git.GetRef(ctx, owner, name, "refs/heads/"+base)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := fmt.Sprintf("github-webhook-rotate/%s", time.Now().UTC().Format("20060102150405"))
+	_, _, err = client.Git.CreateRef(ctx, owner, name, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: baseRef.Object.SHA},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []string
+	for _, path := range paths {
+		file, _, resp, err := client.Repositories.GetContents(ctx, owner, name, path,
+			&github.RepositoryContentGetOptions{Ref: branch})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			log.Printf("Skipping %s, not found in %s", path, repoSpec)
+			continue
+		} else if err != nil {
+			return nil, err
+		} else if file == nil {
+			log.Printf("Skipping %s, it's a directory in %s", path, repoSpec)
+			continue
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+
+		// replace whole urls first, then any remaining references to the token
+		newContent := content
+		for _, r := range rotations {
+			newContent = strings.Replace(newContent, r.OldWebhookURL, r.NewWebhookURL, -1)
+			oldToken, _ := getWebhookToken(r.OldWebhookURL)
+			newToken, _ := getWebhookToken(r.NewWebhookURL)
+			if oldToken != "" && newToken != "" {
+				newContent = strings.Replace(newContent, oldToken, newToken, -1)
+			}
+		}
+		if newContent == content {
+			continue
+		}
+
+		log.Printf("Updating %s in %s", path, repoSpec)
+		_, _, err = client.Repositories.UpdateFile(ctx, owner, name, path, &github.RepositoryContentFileOptions{
+			Message: github.String(fmt.Sprintf("Update rotated Buildkite webhook urls in %s", path)),
+			Content: []byte(newContent),
+			SHA:     file.SHA,
+			Branch:  github.String(branch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, path)
+	}
+
+	if len(updated) == 0 {
+		log.Printf("No files in %s refer to the rotated webhooks", repoSpec)
+		_, err = client.Git.DeleteRef(ctx, owner, name, "refs/heads/"+branch)
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Buildkite webhooks were rotated for the following pipelines:\n\n")
+	for _, r := range rotations {
+		fmt.Fprintf(&body, "* https://buildkite.com/%s\n", r.Pipeline.String())
+	}
+
+	pr, _, err := client.PullRequests.Create(ctx, owner, name, &github.NewPullRequest{
+		Title: github.String("Update rotated Buildkite webhook urls"),
+		Head:  github.String(branch),
+		Base:  github.String(base),
+		Body:  github.String(body.String()),
+	})
+	return pr, err
+}
@@ -3,9 +3,13 @@ module github.com/buildkite/github-webhook-rotate
 go 1.12
 
 require (
+	cloud.google.com/go v0.26.0 // indirect
 	github.com/Songmu/prompter v0.2.0
+	github.com/aws/aws-sdk-go v1.25.0
 	github.com/buildkite/cli v0.5.0
 	github.com/fatih/color v1.7.0
 	github.com/google/go-github/v25 v25.0.4
+	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
+	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6
 )
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// hookFixes are changes that tighten up a hook's configuration, which are applied along
+// with the new webhook url during rotation
+type hookFixes struct {
+	ContentType bool
+	InsecureSSL bool
+	Reactivate  bool
+	AlignEvents bool
+
+	// Events are the events the pipeline builds from according to its provider settings,
+	// nil if they aren't known
+	Events []string
+}
+
+// hookContentType is the payload format of a hook, github defaults to form
+func hookContentType(hook *github.Hook) string {
+	if contentType, ok := hook.Config["content_type"].(string); ok && contentType != "" {
+		return contentType
+	}
+	return "form"
+}
+
+// hookURL is the url a hook delivers to, or empty if its config doesn't have one. Hooks
+// for some integrations have no url, or one that isn't a string.
+func hookURL(hook *github.Hook) string {
+	webhookURL, _ := hook.Config["url"].(string)
+	return webhookURL
+}
+
+// unparseableReason is why a hook's url can't be used to tell which pipeline it delivers to,
+// or empty if it can. Parse errors are left out as they include the url and its token.
+func unparseableReason(hook *github.Hook) string {
+	webhookURL, ok := hook.Config["url"].(string)
+	if !ok {
+		if hook.Config["url"] == nil {
+			return "its config has no url"
+		}
+		return fmt.Sprintf("its config has a %T url rather than a string", hook.Config["url"])
+	}
+	if _, err := getWebhookToken(webhookURL); err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Sprintf("its url can't be parsed: %v", err)
+	}
+	return ""
+}
+
+// isLegacyHook is whether a hook points at the old buildbox.io webhook host. Rotating the
+// pipeline moves it to webhook.buildkite.com.
+func isLegacyHook(hook *github.Hook) bool {
+	u, err := url.Parse(hookURL(hook))
+	return err == nil && u.Host == "webhook.buildbox.io"
+}
+
+// hookInsecureSSL is whether a hook skips verifying the ssl certificate of the webhook url
+func hookInsecureSSL(hook *github.Hook) bool {
+	switch v := hook.Config["insecure_ssl"].(type) {
+	case string:
+		return v == "1"
+	case float64:
+		return v == 1
+	}
+	return false
+}
+
+// apply adds any fixes needed for a hook to an edit of it
+func (f hookFixes) apply(edit *github.Hook, hook *github.Hook) {
+	if f.ContentType && hookContentType(hook) == "form" {
+		edit.Config["content_type"] = "json"
+	}
+	if f.InsecureSSL && hookInsecureSSL(hook) {
+		edit.Config["insecure_ssl"] = "0"
+	}
+	if f.Reactivate && !hook.GetActive() {
+		edit.Active = github.Bool(true)
+	}
+	if f.AlignEvents && len(f.Events) > 0 && !hookSubscribesToAll(hook) && !sameEvents(hook.Events, f.Events) {
+		edit.Events = f.Events
+	}
+}
+
+// hookSubscribesToAll is whether a hook is sent every event, which is left alone
+func hookSubscribesToAll(hook *github.Hook) bool {
+	for _, event := range hook.Events {
+		if event == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// hookWarnings describes problems with a hook's configuration, including events that don't
+// match what the pipeline builds from if they're known
+func hookWarnings(hook *github.Hook, events []string) []string {
+	var warnings []string
+	if isLegacyHook(hook) {
+		warnings = append(warnings, "Points at the legacy webhook.buildbox.io, it will move to webhook.buildkite.com")
+	}
+	if hookContentType(hook) == "form" {
+		warnings = append(warnings, "Uses form payloads, Buildkite recommends json (fix with --fix-content-type)")
+	}
+	if !hook.GetActive() {
+		warnings = append(warnings, "Is inactive, so github isn't delivering events to buildkite (fix with --reactivate)")
+	}
+	if hookInsecureSSL(hook) {
+		warnings = append(warnings, "Doesn't verify SSL certificates (fix with --fix-insecure-ssl)")
+	}
+	if len(events) > 0 && !hookSubscribesToAll(hook) && !sameEvents(hook.Events, events) {
+		warnings = append(warnings, fmt.Sprintf("Subscribes to %s but the pipeline builds from %s (fix with --align-events)",
+			strings.Join(hook.Events, ", "), strings.Join(events, ", ")))
+	}
+	return warnings
+}
+
+// hookModifiedError is returned when a hook has been changed by someone else since it was
+// listed, so it isn't edited
+type hookModifiedError struct {
+	githubRepositoryHook
+}
+
+func (e *hookModifiedError) Error() string {
+	return fmt.Sprintf("%s/settings/hooks/%d has been modified by someone else since it was listed",
+		e.githubRepository.URL(), *e.Hook.ID)
+}
+
+// hookDelivery is an attempt by github to deliver an event to a hook
+// https://docs.github.com/en/rest/webhooks/repo-deliveries
+type hookDelivery struct {
+	ID          int64     `json:"id"`
+	Event       string    `json:"event"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	StatusCode  int       `json:"status_code"`
+	Status      string    `json:"status"`
+}
+
+func listHookDeliveries(ctx context.Context, client *github.Client, repoHook githubRepositoryHook) ([]hookDelivery, error) {
+	u := fmt.Sprintf("repos/%s/%s/hooks/%d/deliveries?per_page=30", repoHook.Org, repoHook.Name, *repoHook.Hook.ID)
+
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []hookDelivery
+	if _, err = client.Do(ctx, req, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// verifyHookPing pings a hook and checks that buildkite accepted the delivery. Deliveries
+// take a moment to be recorded, so this tries a few times.
+func verifyHookPing(ctx context.Context, client *github.Client, repoHook githubRepositoryHook) error {
+	pingedAt := time.Now().Add(-time.Second)
+
+	if _, err := client.Repositories.PingHook(ctx, repoHook.Org, repoHook.Name, *repoHook.Hook.ID); err != nil {
+		return fmt.Errorf("Failed to ping hook: %v", err)
+	}
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		time.Sleep(2 * time.Second)
+
+		deliveries, err := listHookDeliveries(ctx, client, repoHook)
+		if err != nil {
+			return fmt.Errorf("Failed to read hook deliveries: %v", err)
+		}
+
+		for _, delivery := range deliveries {
+			if delivery.Event != "ping" || delivery.DeliveredAt.Before(pingedAt) {
+				continue
+			}
+			if delivery.StatusCode < 200 || delivery.StatusCode > 299 {
+				return fmt.Errorf("Buildkite responded to the ping with %d %s", delivery.StatusCode, delivery.Status)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No ping delivery found for hook %d", *repoHook.Hook.ID)
+}
+
+// restoreGithubRepositoryHook undoes the config fixes made to a hook, putting back the rest
+// of its previous config. The previous url was revoked when the pipeline was rotated, so the
+// new one is kept, and the secret isn't returned by github, so it's left as it is.
+func restoreGithubRepositoryHook(ctx context.Context, client *github.Client, repoHook githubRepositoryHook, previous *github.Hook, webhookURL string) error {
+	config := map[string]interface{}{}
+	for k, v := range previous.Config {
+		if k != "secret" {
+			config[k] = v
+		}
+	}
+	config["url"] = webhookURL
+
+	_, _, err := client.Repositories.EditHook(ctx, repoHook.Org, repoHook.Name, *repoHook.Hook.ID, &github.Hook{
+		Config: config,
+		Events: previous.Events,
+		Active: github.Bool(previous.GetActive()),
+	})
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// testGithubClient is a github client for a test server
+func testGithubClient(t *testing.T, handler http.Handler) (*github.Client, func()) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+func TestRestoreGithubRepositoryHook(t *testing.T) {
+	const newWebhookURL = "https://webhook.buildkite.com/deliver/new"
+	previous := &github.Hook{
+		ID:     github.Int64(1001),
+		Active: github.Bool(false),
+		Events: []string{"push"},
+		Config: map[string]interface{}{
+			"url":          "https://webhook.buildkite.com/deliver/old",
+			"content_type": "form",
+			"insecure_ssl": "1",
+			"secret":       "********",
+		},
+	}
+
+	var edit github.Hook
+	client, done := testGithubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/repos/acme/web/hooks/1001" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&edit)
+		json.NewEncoder(w).Encode(edit)
+	}))
+	defer done()
+
+	match := githubRepositoryHook{githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}, &github.Hook{ID: github.Int64(1001)}}
+	if err := restoreGithubRepositoryHook(context.Background(), client, match, previous, newWebhookURL); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{"url": newWebhookURL, "content_type": "form", "insecure_ssl": "1"}
+	if !reflect.DeepEqual(edit.Config, want) {
+		t.Fatalf("Expected config %v, got %v", want, edit.Config)
+	}
+	if edit.GetActive() || !reflect.DeepEqual(edit.Events, previous.Events) {
+		t.Fatalf("Expected the previous events and active state, got %v and %v", edit.Events, edit.GetActive())
+	}
+}
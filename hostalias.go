@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostAliases map the hosts in repository remotes to github hosts, for remotes that use an
+// alias like git@github-work:org/repo.git from an ssh config
+var hostAliases = map[string]string{}
+
+// urlRewrites replace the start of repository remotes before they're parsed, like git's
+// url.<base>.insteadOf, for mirrors or vanity urls of github repositories
+var urlRewrites []urlRewrite
+
+type urlRewrite struct {
+	insteadOf string
+	base      string
+}
+
+// addURLRewrites adds rewrites given like insteadOf=base
+func addURLRewrites(rewrites []string) error {
+	for _, rewrite := range rewrites {
+		parts := strings.SplitN(rewrite, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Expected a url rewrite like git@git.example.com:=git@github.com:, got %q", rewrite)
+		}
+		urlRewrites = append(urlRewrites, urlRewrite{insteadOf: parts[0], base: parts[1]})
+	}
+	return nil
+}
+
+// rewriteRemote applies the rewrite with the longest matching prefix, as git does
+func rewriteRemote(remote string) string {
+	var longest *urlRewrite
+	for i, rewrite := range urlRewrites {
+		if strings.HasPrefix(remote, rewrite.insteadOf) && (longest == nil || len(rewrite.insteadOf) > len(longest.insteadOf)) {
+			longest = &urlRewrites[i]
+		}
+	}
+	if longest == nil {
+		return remote
+	}
+	return longest.base + strings.TrimPrefix(remote, longest.insteadOf)
+}
+
+// resolveHost returns the github host that a remote's host refers to
+func resolveHost(host string) string {
+	if resolved, ok := hostAliases[host]; ok {
+		return resolved
+	}
+	return host
+}
+
+// addHostAliases adds aliases given like alias=host
+func addHostAliases(aliases []string) error {
+	for _, alias := range aliases {
+		parts := strings.SplitN(alias, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Expected a host alias like alias=host, got %q", alias)
+		}
+		hostAliases[strings.ToLower(parts[0])] = strings.ToLower(parts[1])
+	}
+	return nil
+}
+
+func defaultSSHConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// readSSHConfigAliases adds the aliases of Host entries in an ssh config that have a HostName.
+// Patterns can't be resolved to a single host, so they're ignored.
+func readSSHConfigAliases(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// keywords are separated from their arguments by whitespace or an equals sign, and
+		// arguments can be quoted
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], `"`)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "host":
+			hosts = hosts[:0]
+			for _, host := range fields[1:] {
+				if !strings.ContainsAny(host, "*?!") {
+					hosts = append(hosts, strings.ToLower(host))
+				}
+			}
+		case "match":
+			hosts = hosts[:0]
+		case "hostname":
+			// tokens like %h can't be resolved without connecting
+			if strings.Contains(fields[1], "%") {
+				continue
+			}
+			for _, host := range hosts {
+				// like ssh, the first value for a host wins, and flags win over the config
+				if _, ok := hostAliases[host]; !ok && host != strings.ToLower(fields[1]) {
+					hostAliases[host] = strings.ToLower(fields[1])
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
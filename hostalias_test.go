@@ -0,0 +1,294 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withHostRewrites empties the host aliases and url rewrites for a test, returning a func
+// that restores them
+func withHostRewrites() func() {
+	aliases, rewrites := hostAliases, urlRewrites
+	hostAliases, urlRewrites = map[string]string{}, nil
+	return func() {
+		hostAliases, urlRewrites = aliases, rewrites
+	}
+}
+
+func writeTempFile(t *testing.T, contents string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "ssh-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestReadSSHConfigAliases(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		flags  []string
+		want   map[string]string
+	}{
+		{
+			name:   "host with a hostname",
+			config: "Host github-work\n  HostName github.com\n  User git\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "several hosts in one entry",
+			config: "Host github-work github-personal\n\tHostName github.com\n",
+			want:   map[string]string{"github-work": "github.com", "github-personal": "github.com"},
+		},
+		{
+			name:   "keywords and hosts are case insensitive",
+			config: "HOST GitHub-Work\n  hostname GitHub.com\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "key=value syntax",
+			config: "Host=github-work\nHostName=github.com\n\nHost ghe-alias\n  HostName = ghe.example.com\n",
+			want:   map[string]string{"github-work": "github.com", "ghe-alias": "ghe.example.com"},
+		},
+		{
+			name:   "quoted values",
+			config: "Host \"github-work\"\n  HostName \"github.com\"\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "comments and blank lines",
+			config: "# work account\n\nHost github-work\n  # HostName ignored.example.com\n  HostName github.com\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "patterns are ignored",
+			config: "Host *.example.com gh? !github-bastion github-work\n  HostName github.com\n\nHost *\n  HostName other.example.com\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "tokens are ignored",
+			config: "Host github-work\n  HostName %h.example.com\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "match blocks end the host entry",
+			config: "Host github-work\n  User git\nMatch exec \"true\"\n  HostName other.example.com\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "host entries after a match block",
+			config: "Match all\n  HostName other.example.com\nHost github-work\n  HostName github.com\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "the first hostname wins",
+			config: "Host github-work\n  HostName github.com\n\nHost github-work\n  HostName other.example.com\n",
+			want:   map[string]string{"github-work": "github.com"},
+		},
+		{
+			name:   "hostnames that are the host are ignored",
+			config: "Host github.com\n  HostName github.com\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "hostname without a host",
+			config: "HostName github.com\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "flags win over the config",
+			config: "Host github-work\n  HostName other.example.com\n",
+			flags:  []string{"github-work=github.com"},
+			want:   map[string]string{"github-work": "github.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer withHostRewrites()()
+			path := writeTempFile(t, tc.config)
+			defer os.Remove(path)
+
+			if err := addHostAliases(tc.flags); err != nil {
+				t.Fatal(err)
+			}
+			if err := readSSHConfigAliases(path); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hostAliases, tc.want) {
+				t.Fatalf("Expected aliases %v, got %v", tc.want, hostAliases)
+			}
+		})
+	}
+}
+
+func TestReadSSHConfigAliasesMissingFile(t *testing.T) {
+	defer withHostRewrites()()
+	path := writeTempFile(t, "")
+	defer os.Remove(path)
+
+	if err := readSSHConfigAliases(path + ".missing"); !os.IsNotExist(err) {
+		t.Fatalf("Expected a not exist error, got %v", err)
+	}
+}
+
+func TestAddHostAliases(t *testing.T) {
+	for _, tc := range []struct {
+		aliases []string
+		want    map[string]string
+		valid   bool
+	}{
+		{[]string{"github-work=github.com"}, map[string]string{"github-work": "github.com"}, true},
+		{[]string{"GitHub-Work=GitHub.com"}, map[string]string{"github-work": "github.com"}, true},
+		{[]string{"a=github.com", "b=ghe.example.com"}, map[string]string{"a": "github.com", "b": "ghe.example.com"}, true},
+		{[]string{"github-work"}, nil, false},
+		{[]string{"=github.com"}, nil, false},
+		{[]string{"github-work="}, nil, false},
+	} {
+		t.Run(strings.Join(tc.aliases, " "), func(t *testing.T) {
+			defer withHostRewrites()()
+			err := addHostAliases(tc.aliases)
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("Expected %v to be invalid", tc.aliases)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hostAliases, tc.want) {
+				t.Fatalf("Expected aliases %v, got %v", tc.want, hostAliases)
+			}
+		})
+	}
+}
+
+func TestParseGithubRepositoryWithAliases(t *testing.T) {
+	defer withHostRewrites()()
+	if err := addHostAliases([]string{"github-work=github.com", "ghe-alias=ghe.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remote string
+		want   githubRepository
+	}{
+		{"git@github-work:acme/web.git", githubRepository{"github.com", "acme", "web", "git@github-work:acme/web.git"}},
+		{"ssh://git@GitHub-Work/acme/web.git", githubRepository{"github.com", "acme", "web", "ssh://git@GitHub-Work/acme/web.git"}},
+		{"git@ghe-alias:acme/api.git", githubRepository{"ghe.example.com", "acme", "api", "git@ghe-alias:acme/api.git"}},
+		{"git@github.com:acme/docs.git", githubRepository{"github.com", "acme", "docs", "git@github.com:acme/docs.git"}},
+		{"git@other.example.com:acme/docs.git", githubRepository{"other.example.com", "acme", "docs", "git@other.example.com:acme/docs.git"}},
+	} {
+		t.Run(tc.remote, func(t *testing.T) {
+			repo, err := parseGithubRepository(tc.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repo != tc.want {
+				t.Fatalf("Expected %#v, got %#v", tc.want, repo)
+			}
+		})
+	}
+}
+
+func TestRewriteRemote(t *testing.T) {
+	defer withHostRewrites()()
+	err := addURLRewrites([]string{
+		"git@git.example.com:=git@github.com:",
+		"git@git.example.com:platform/=git@ghe.example.com:platform/",
+		"https://git.example.com/=https://github.com/",
+		"mirror:=git@github.com:acme/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remote string
+		want   string
+	}{
+		{"git@git.example.com:acme/web.git", "git@github.com:acme/web.git"},
+		{"git@git.example.com:platform/api.git", "git@ghe.example.com:platform/api.git"},
+		{"git@git.example.com:platform-tools/api.git", "git@github.com:platform-tools/api.git"},
+		{"https://git.example.com/acme/docs.git", "https://github.com/acme/docs.git"},
+		{"mirror:sandbox.git", "git@github.com:acme/sandbox.git"},
+		{"git@github.com:acme/web.git", "git@github.com:acme/web.git"},
+		{"ssh://git@git.example.com/acme/web.git", "ssh://git@git.example.com/acme/web.git"},
+		{"https://git.example.com.evil.com/acme/web.git", "https://git.example.com.evil.com/acme/web.git"},
+	} {
+		t.Run(tc.remote, func(t *testing.T) {
+			if got := rewriteRemote(tc.remote); got != tc.want {
+				t.Fatalf("Expected %q to be rewritten to %q, got %q", tc.remote, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRewriteRemoteFirstOfEqualLength(t *testing.T) {
+	defer withHostRewrites()()
+	if err := addURLRewrites([]string{"git@a.example.com:=git@github.com:", "git@a.example.com:=git@ghe.example.com:"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rewriteRemote("git@a.example.com:acme/web.git"); got != "git@github.com:acme/web.git" {
+		t.Fatalf("Expected the first rewrite to be used, got %q", got)
+	}
+}
+
+func TestAddURLRewrites(t *testing.T) {
+	for _, tc := range []struct {
+		rewrite string
+		want    urlRewrite
+		valid   bool
+	}{
+		{"git@git.example.com:=git@github.com:", urlRewrite{"git@git.example.com:", "git@github.com:"}, true},
+		{"https://git.example.com/=https://github.com/?a=b", urlRewrite{"https://git.example.com/", "https://github.com/?a=b"}, true},
+		{"git@git.example.com:", urlRewrite{}, false},
+		{"=git@github.com:", urlRewrite{}, false},
+		{"git@git.example.com:=", urlRewrite{}, false},
+	} {
+		t.Run(tc.rewrite, func(t *testing.T) {
+			defer withHostRewrites()()
+			err := addURLRewrites([]string{tc.rewrite})
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("Expected %q to be invalid", tc.rewrite)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(urlRewrites) != 1 || urlRewrites[0] != tc.want {
+				t.Fatalf("Expected %v, got %v", tc.want, urlRewrites)
+			}
+		})
+	}
+}
+
+func TestParseGithubRepositoryWithRewrites(t *testing.T) {
+	defer withHostRewrites()()
+	if err := addURLRewrites([]string{"git@git.example.com:=git@github-work:"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addHostAliases([]string{"github-work=github.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrites are applied before aliases, and the original remote is kept
+	repo, err := parseGithubRepository("git@git.example.com:acme/web.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (githubRepository{"github.com", "acme", "web", "git@git.example.com:acme/web.git"}); repo != want {
+		t.Fatalf("Expected %#v, got %#v", want, repo)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// inventoryRecord is a single pipeline -> repository hook mapping, pipelines without
+// matching hooks have no hook id and unknown hooks have no pipeline
+type inventoryRecord struct {
+	Pipeline     string `json:"pipeline"`
+	PipelineID   string `json:"pipeline_id"`
+	WebhookToken string `json:"webhook_token"`
+	Repository   string `json:"repository"`
+	HookID       int64  `json:"hook_id,omitempty"`
+	HookURL      string `json:"hook_url,omitempty"`
+
+	// when the hook was created and last changed, and last delivered to if that was looked up,
+	// which show how stale a webhook is but aren't part of the mapping itself
+	HookCreatedAt      *time.Time `json:"hook_created_at,omitempty"`
+	HookUpdatedAt      *time.Time `json:"hook_updated_at,omitempty"`
+	LastDeliveryAt     *time.Time `json:"last_delivery_at,omitempty"`
+	LastDeliveryStatus int        `json:"last_delivery_status,omitempty"`
+
+	// the pipeline's provider settings if they were looked up, and how the hook is
+	// inconsistent with them
+	ProviderSettings *providerSettings `json:"provider_settings,omitempty"`
+	ProviderMismatch string            `json:"provider_mismatch,omitempty"`
+
+	// why the hook was left alone, for hooks whose url can't be parsed
+	Unparseable string `json:"unparseable,omitempty"`
+}
+
+type inventoryExport struct {
+	Organization string            `json:"organization"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+	Records      []inventoryRecord `json:"records"`
+}
+
+func (inv *inventory) records() []inventoryRecord {
+	var records []inventoryRecord
+
+	for _, pipeline := range inv.Pipelines {
+		matches, ok := inv.TokenHooks[pipeline.WebhookToken]
+		if !ok {
+			records = append(records, inventoryRecord{
+				Pipeline:     pipeline.String(),
+				PipelineID:   pipeline.ID,
+				WebhookToken: maskToken(pipeline.WebhookToken),
+				Repository:   pipeline.Repository.String(),
+			})
+			continue
+		}
+		for _, match := range matches {
+			records = append(records, inventoryRecord{
+				Pipeline:     pipeline.String(),
+				PipelineID:   pipeline.ID,
+				WebhookToken: maskToken(pipeline.WebhookToken),
+				Repository:   match.githubRepository.String(),
+				HookID:       *match.Hook.ID,
+				HookURL:      maskWebhookURL(hookURL(match.Hook)),
+			}.withHookTimes(inv, match.githubRepository, match.Hook).withProviderSettings(inv, pipeline, match.Hook))
+		}
+	}
+
+	// unknown hooks are listed per repository in a stable order
+	for _, repo := range inv.repositories() {
+		for _, hook := range inv.unknownHooks(repo) {
+			webhookURL := hookURL(hook)
+			token, _ := getWebhookToken(webhookURL)
+			records = append(records, inventoryRecord{
+				WebhookToken: maskToken(token),
+				Repository:   repo.String(),
+				HookID:       *hook.ID,
+				HookURL:      maskWebhookURL(webhookURL),
+			}.withHookTimes(inv, repo, hook))
+		}
+	}
+
+	for _, hook := range inv.UnparseableHooks {
+		records = append(records, inventoryRecord{
+			Repository:  hook.githubRepository.String(),
+			HookID:      hook.GetID(),
+			Unparseable: hook.Reason,
+		}.withHookTimes(inv, hook.githubRepository, hook.Hook))
+	}
+
+	return records
+}
+
+// withHookTimes adds when a hook was created, updated and last delivered to
+func (r inventoryRecord) withHookTimes(inv *inventory, repo githubRepository, hook *github.Hook) inventoryRecord {
+	r.HookCreatedAt, r.HookUpdatedAt = hook.CreatedAt, hook.UpdatedAt
+	if delivery, ok := inv.LastDeliveries.last(repo, *hook.ID); ok {
+		deliveredAt := delivery.DeliveredAt
+		r.LastDeliveryAt = &deliveredAt
+		r.LastDeliveryStatus = delivery.StatusCode
+	}
+	return r
+}
+
+// withProviderSettings adds the pipeline's provider settings and any inconsistency with the hook
+func (r inventoryRecord) withProviderSettings(inv *inventory, p pipeline, hook *github.Hook) inventoryRecord {
+	if settings, ok := inv.ProviderSettings[p.ID]; ok {
+		r.ProviderSettings = &settings
+		r.ProviderMismatch = settings.mismatch(hook)
+	}
+	return r
+}
+
+// hookMapping maps a github hook to the pipeline it delivers to by their durable ids, for
+// other tooling to refer to webhooks by rather than their urls
+type hookMapping struct {
+	Host         string `json:"host"`
+	Repository   string `json:"repository"`
+	HookID       int64  `json:"hook_id"`
+	PipelineID   string `json:"pipeline_id,omitempty"`
+	PipelineSlug string `json:"pipeline_slug,omitempty"`
+}
+
+// writeHookMapping writes every buildkite hook with the pipeline it delivers to, if any.
+// The output has no timestamps or urls and is sorted, so it only changes when the mapping does.
+func writeHookMapping(w io.Writer, inv *inventory) error {
+	hooks := []hookMapping{}
+	for _, r := range inv.records() {
+		if r.HookID == 0 || r.Unparseable != "" {
+			continue
+		}
+		repo, err := parseRepositoryName(r.Repository)
+		if err != nil {
+			return err
+		}
+		mapping := hookMapping{Host: repo.Host, Repository: repo.Org + "/" + repo.Name, HookID: r.HookID, PipelineID: r.PipelineID}
+		if r.Pipeline != "" {
+			mapping.PipelineSlug = r.Pipeline[strings.Index(r.Pipeline, "/")+1:]
+		}
+		hooks = append(hooks, mapping)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Host != hooks[j].Host {
+			return hooks[i].Host < hooks[j].Host
+		}
+		if hooks[i].Repository != hooks[j].Repository {
+			return hooks[i].Repository < hooks[j].Repository
+		}
+		if hooks[i].HookID != hooks[j].HookID {
+			return hooks[i].HookID < hooks[j].HookID
+		}
+		return hooks[i].PipelineID < hooks[j].PipelineID
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Organization string        `json:"organization"`
+		Hooks        []hookMapping `json:"hooks"`
+	}{inv.Org, hooks})
+}
+
+// repositories returns the distinct repositories backing the pipelines, sorted by name
+func (inv *inventory) repositories() []githubRepository {
+	var repos []githubRepository
+	seen := map[string]bool{}
+	for _, pipeline := range inv.Pipelines {
+		if seen[pipeline.Repository.String()] {
+			continue
+		}
+		seen[pipeline.Repository.String()] = true
+		repos = append(repos, pipeline.Repository)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].String() < repos[j].String()
+	})
+	return repos
+}
+
+func writeInventory(w io.Writer, inv *inventory, format string) error {
+	records := inv.records()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(inventoryExport{
+			Organization: inv.Org,
+			GeneratedAt:  time.Now().UTC(),
+			Records:      records,
+		})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"pipeline", "pipeline_id", "webhook_token", "repository", "hook_id", "hook_url",
+			"hook_created_at", "hook_updated_at", "last_delivery_at", "last_delivery_status", "provider_mismatch", "unparseable"})
+		for _, r := range records {
+			var hookID, status string
+			if r.HookID != 0 {
+				hookID = strconv.FormatInt(r.HookID, 10)
+			}
+			if r.LastDeliveryStatus != 0 {
+				status = strconv.Itoa(r.LastDeliveryStatus)
+			}
+			cw.Write([]string{r.Pipeline, r.PipelineID, r.WebhookToken, r.Repository, hookID, r.HookURL,
+				csvTime(r.HookCreatedAt), csvTime(r.HookUpdatedAt), csvTime(r.LastDeliveryAt), status, r.ProviderMismatch, r.Unparseable})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "terraform":
+		return writeTerraform(w, inv)
+	case "hooks":
+		return writeHookMapping(w, inv)
+	default:
+		return fmt.Errorf("Unknown format %q", format)
+	}
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// maskToken keeps enough of a webhook token to tell them apart without disclosing it
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return strings.Repeat("*", len(token))
+	}
+	return token[:4] + strings.Repeat("*", len(token)-4)
+}
+
+// tokenFingerprint is a short hash of a webhook token that can't be reversed, for telling
+// tokens apart in reports and audit logs without storing them
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func maskWebhookURL(webhookURL string) string {
+	token, err := getWebhookToken(webhookURL)
+	if err != nil || token == "" {
+		return webhookURL
+	}
+	return strings.Replace(webhookURL, token, maskToken(token), 1)
+}
+
+func readInventory(path string) (*inventoryExport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var export inventoryExport
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		return nil, fmt.Errorf("Failed to parse inventory %s: %v", path, err)
+	}
+	return &export, nil
+}
+
+// mapping is the record without the hook times and provider settings, which change without
+// the mapping changing
+func (r inventoryRecord) mapping() inventoryRecord {
+	r.HookCreatedAt, r.HookUpdatedAt, r.LastDeliveryAt, r.LastDeliveryStatus = nil, nil, nil, 0
+	r.ProviderSettings, r.ProviderMismatch = nil, ""
+	return r
+}
+
+// key identifies a record by the pipeline and the hook it refers to
+func (r inventoryRecord) key() string {
+	return fmt.Sprintf("%s|%s|%d", r.Pipeline, r.Repository, r.HookID)
+}
+
+func (r inventoryRecord) String() string {
+	pipeline := r.Pipeline
+	if pipeline == "" {
+		pipeline = "(unknown pipeline)"
+	}
+	if r.HookID == 0 {
+		return fmt.Sprintf("%s -> %s (no matching hook)", pipeline, githubURL(r.Repository))
+	}
+	if r.Unparseable != "" {
+		return fmt.Sprintf("%s -> %s/settings/hooks/%d (left alone, %s)", pipeline, githubURL(r.Repository), r.HookID, r.Unparseable)
+	}
+	return fmt.Sprintf("%s -> %s/settings/hooks/%d", pipeline, githubURL(r.Repository), r.HookID)
+}
+
+type inventoryChange struct {
+	Before inventoryRecord
+	After  inventoryRecord
+}
+
+type inventoryDiff struct {
+	Added   []inventoryRecord
+	Removed []inventoryRecord
+	Changed []inventoryChange
+}
+
+func (d inventoryDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffInventories compares what we believed (before) with what exists now (after)
+func diffInventories(before, after []inventoryRecord) inventoryDiff {
+	var diff inventoryDiff
+
+	beforeByKey := map[string]inventoryRecord{}
+	for _, r := range before {
+		beforeByKey[r.key()] = r
+	}
+	afterByKey := map[string]inventoryRecord{}
+	for _, r := range after {
+		afterByKey[r.key()] = r
+	}
+
+	for _, r := range after {
+		b, ok := beforeByKey[r.key()]
+		if !ok {
+			diff.Added = append(diff.Added, r)
+		} else if b.mapping() != r.mapping() {
+			diff.Changed = append(diff.Changed, inventoryChange{b, r})
+		}
+	}
+	for _, r := range before {
+		if _, ok := afterByKey[r.key()]; !ok {
+			diff.Removed = append(diff.Removed, r)
+		}
+	}
+
+	return diff
+}
+
+func printInventoryDiff(w io.Writer, diff inventoryDiff) {
+	for _, r := range diff.Added {
+		fmt.Fprintf(w, color.GreenString("+ %s\n"), r)
+	}
+	for _, r := range diff.Removed {
+		fmt.Fprintf(w, color.RedString("- %s\n"), r)
+	}
+	for _, c := range diff.Changed {
+		fmt.Fprintf(w, color.YellowString("~ %s\n"), c.After)
+		if c.Before.PipelineID != c.After.PipelineID {
+			fmt.Fprintf(w, "\tPipeline ID: %s → %s\n", c.Before.PipelineID, c.After.PipelineID)
+		}
+		if c.Before.WebhookToken != c.After.WebhookToken {
+			fmt.Fprintf(w, "\tWebhook Token: %s → %s\n", c.Before.WebhookToken, c.After.WebhookToken)
+		}
+		if c.Before.HookURL != c.After.HookURL {
+			fmt.Fprintf(w, "\tHook URL: %s → %s\n", c.Before.HookURL, c.After.HookURL)
+		}
+	}
+	fmt.Fprintf(w, "\n%d added, %d removed, %d changed\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+// printOverview summarizes what's in scope, to set expectations before any changes are made
+func printOverview(w io.Writer, inv *inventory) {
+	var matched, unknown, unmatched, formHooks, insecureHooks, inactiveHooks, sharedHooks int
+	for _, pipeline := range inv.Pipelines {
+		if matches, ok := inv.TokenHooks[pipeline.WebhookToken]; ok {
+			matched += len(matches)
+			if shared := inv.pipelinesWithToken(pipeline.WebhookToken); len(shared) > 1 && shared[0].ID == pipeline.ID {
+				sharedHooks += len(matches)
+			}
+			for _, match := range matches {
+				if hookContentType(match.Hook) == "form" {
+					formHooks++
+				}
+				if !match.Hook.GetActive() {
+					inactiveHooks++
+				}
+				if hookInsecureSSL(match.Hook) {
+					insecureHooks++
+				}
+			}
+		} else {
+			unmatched++
+		}
+	}
+	var legacyHooks int
+	repos := inv.repositories()
+	for _, repo := range repos {
+		unknown += len(inv.unknownHooks(repo))
+		for _, hook := range inv.RepositoryHooks[repo.String()] {
+			if isLegacyHook(hook) {
+				legacyHooks++
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pipelines in scope\t%d\n", len(inv.Pipelines))
+	fmt.Fprintf(tw, "Repositories\t%d\n", len(repos))
+	fmt.Fprintf(tw, "Matched hooks\t%d\n", matched)
+	fmt.Fprintf(tw, "Unknown hooks\t%d\n", unknown)
+	fmt.Fprintf(tw, "Legacy buildbox.io hooks\t%d\n", legacyHooks)
+	fmt.Fprintf(tw, "Inactive hooks\t%d\n", inactiveHooks)
+	fmt.Fprintf(tw, "Hooks with form payloads\t%d\n", formHooks)
+	fmt.Fprintf(tw, "Hooks without SSL verification\t%d\n", insecureHooks)
+	fmt.Fprintf(tw, "Hooks serving several pipelines\t%d\n", sharedHooks)
+	fmt.Fprintf(tw, "Pipelines with no matching hooks\t%d\n", unmatched)
+	tw.Flush()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+func TestUnparseableHooks(t *testing.T) {
+	repo := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}
+	p := pipeline{ID: "web-id", Org: "acme", Slug: "web", Repository: repo, WebhookToken: "0a1b2c3d4e5f"}
+	hook := func(id int64, webhookURL interface{}) *github.Hook {
+		config := map[string]interface{}{}
+		if webhookURL != nil {
+			config["url"] = webhookURL
+		}
+		return &github.Hook{ID: github.Int64(id), Config: config}
+	}
+	inv := newInventory("acme", []pipeline{p}, map[string][]*github.Hook{"acme/web": {
+		hook(1001, "https://webhook.buildkite.com/deliver/0a1b2c3d4e5f"),
+		hook(1002, nil),
+		hook(1003, 42.0),
+		hook(1004, "https://webhook.buildkite.com/deliver/%zz0a1b2c3d4e5f"),
+	}})
+
+	if len(inv.TokenHooks[p.WebhookToken]) != 1 || len(inv.RepositoryHooks["acme/web"]) != 1 {
+		t.Fatalf("Expected only the parseable hook to be mapped, got %v", inv.TokenHooks)
+	}
+
+	var got []inventoryRecord
+	for _, r := range inv.records() {
+		if r.Unparseable != "" {
+			got = append(got, r)
+		}
+	}
+	want := []inventoryRecord{
+		{Repository: "acme/web", HookID: 1002, Unparseable: "its config has no url"},
+		{Repository: "acme/web", HookID: 1003, Unparseable: "its config has a float64 url rather than a string"},
+		{Repository: "acme/web", HookID: 1004, Unparseable: `its url can't be parsed: invalid URL escape "%zz"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected unparseable records %v, got %v", want, got)
+	}
+
+	// the hooks are kept in the cache, so they're reported when planning offline too
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := &pipelineCache{dir: dir}
+	if err := cache.saveInventory(inv); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := cache.loadInventory("acme", pipelineFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.UnparseableHooks) != 3 || len(loaded.RepositoryHooks["acme/web"]) != 1 {
+		t.Fatalf("Expected the cached inventory to have 3 unparseable hooks, got %d", len(loaded.UnparseableHooks))
+	}
+}
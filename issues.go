@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// codeownersPaths are the locations github looks for a CODEOWNERS file
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// getCodeowners returns the users that own everything in a repository, such as those in
+// a `* @user` rule. Teams and emails aren't returned as issues can't be assigned to them.
+func getCodeowners(ctx context.Context, client *github.Client, repo githubRepository) []string {
+	for _, path := range codeownersPaths {
+		file, _, _, err := client.Repositories.GetContents(ctx, repo.Org, repo.Name, path, nil)
+		if err != nil || file == nil {
+			continue
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			continue
+		}
+		return parseCodeowners(content)
+	}
+	return nil
+}
+
+func parseCodeowners(content string) []string {
+	var owners []string
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "*" {
+			continue
+		}
+		// the last matching rule takes precedence
+		owners = nil
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "#") {
+				break
+			}
+			if strings.HasPrefix(owner, "@") && !strings.Contains(owner, "/") {
+				owners = append(owners, strings.TrimPrefix(owner, "@"))
+			}
+		}
+	}
+
+	return owners
+}
+
+// openHookFailureIssue opens an issue describing the manual fix for a hook that couldn't be
+// updated after its pipeline's webhook was rotated. Issues are opened on the repository itself
+// unless a central repository (org/name) is provided.
+func openHookFailureIssue(ctx context.Context, client *github.Client, centralRepo string, match githubRepositoryHook, p pipeline, updateErr error) (*github.Issue, error) {
+	owner, name := match.Org, match.Name
+	if centralRepo != "" {
+		repoParts := strings.SplitN(centralRepo, "/", 2)
+		if len(repoParts) != 2 {
+			return nil, fmt.Errorf("Expected an issues repository like org/name, got %q", centralRepo)
+		}
+		owner, name = repoParts[0], repoParts[1]
+	}
+
+	body := fmt.Sprintf(`The Buildkite webhook for https://buildkite.com/%s was rotated, but the GitHub webhook that triggers its builds couldn't be updated:
+
+> %v
+
+Until it's fixed, pushes to %s won't trigger builds. To fix it:
+
+1. Copy the new webhook URL from the pipeline's GitHub settings at https://buildkite.com/%s/settings/setup/github
+2. Update the Payload URL of %s/settings/hooks/%d
+`, p.String(), updateErr, match.githubRepository.URL(), p.String(), match.githubRepository.URL(), *match.Hook.ID)
+
+	var assignees []string
+	if centralRepo == "" {
+		assignees = getCodeowners(ctx, client, match.githubRepository)
+	}
+
+	issue, _, err := client.Issues.Create(ctx, owner, name, &github.IssueRequest{
+		Title:     github.String(fmt.Sprintf("Update the Buildkite webhook for %s", p.String())),
+		Body:      github.String(body),
+		Assignees: &assignees,
+	})
+	return issue, err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+)
+
+// listingCounts are how many pipelines an organization has and why they were left out of a
+// listing, to explain a listing that comes back empty
+type listingCounts struct {
+	total     int
+	filtered  int
+	archived  int
+	nonGithub int
+}
+
+// errOrganizationNotFound explains an organization slug that the api doesn't know about
+func errOrganizationNotFound(org string) error {
+	return fmt.Errorf("Organization %q wasn't found. Check the slug, it's the part after "+
+		"https://buildkite.com/ in the organization's URL, and that the API token was given access "+
+		"to the organization when it was created", org)
+}
+
+// explain logs why no pipelines were listed, with suggestions of what to try
+func (c listingCounts) explain(org string, found int) {
+	if found > 0 {
+		return
+	}
+	if c.total == 0 {
+		log.Printf(color.YellowString("⚠️  No pipelines found in %s. Either it has no pipelines, or the "+
+			"API token can't see them, check the token's user is a member of teams with access to them", org))
+		return
+	}
+
+	log.Printf(color.YellowString("⚠️  None of the %d pipelines in %s can be rotated:", c.total, org))
+	if c.filtered > 0 {
+		log.Printf(color.YellowString("⚠️  %d don't match --pipeline, --cluster or --tag, check for typos", c.filtered))
+	}
+	if c.archived > 0 {
+		log.Printf(color.YellowString("⚠️  %d are archived, use --include-archived to include them", c.archived))
+	}
+	if c.nonGithub > 0 {
+		log.Printf(color.YellowString("⚠️  %d don't build from GitHub repositories", c.nonGithub))
+	}
+}
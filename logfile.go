@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingLog is a log file that's rotated once it reaches a size or age, keeping a few
+// of the previous files, so long running watches keep durable logs without filling the disk
+type rotatingLog struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func openRotatingLog(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.openedAt = f, info.Size(), info.ModTime()
+	if l.size == 0 {
+		l.openedAt = time.Now()
+	}
+	return nil
+}
+
+// ansiPattern matches terminal colors, which are left out of log files
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func (l *rotatingLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := ansiPattern.ReplaceAll(b, nil)
+
+	if (l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize && l.size > 0) ||
+		(l.maxAge > 0 && time.Since(l.openedAt) > l.maxAge) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", l.path, err)
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rotate moves the current file aside with a timestamp, and removes the oldest backups
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.%s", l.path, time.Now().UTC().Format("20060102T150405.000Z"))
+	if err := os.Rename(l.path, backup); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	backups, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > l.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
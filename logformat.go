@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// rfc3339Millis is RFC3339 with milliseconds, which is enough to line logs up with
+// the timestamps of audit events
+const rfc3339Millis = "2006-01-02T15:04:05.000Z07:00"
+
+// utcLogWriter prefixes each log line with an RFC3339 timestamp in UTC, which the log
+// package can't do itself
+type utcLogWriter struct {
+	w io.Writer
+}
+
+func (u utcLogWriter) Write(p []byte) (int, error) {
+	line := append([]byte(time.Now().UTC().Format(rfc3339Millis)+" "), p...)
+	if _, err := u.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setLogTimestamps configures how log lines are prefixed, either with the local time
+// (and the date when logging to a file), an RFC3339 timestamp in UTC, or nothing for
+// log collectors that add their own
+func setLogTimestamps(format string, w io.Writer, withDate bool) error {
+	switch format {
+	case "local":
+		log.SetOutput(w)
+		if withDate {
+			log.SetFlags(log.LstdFlags)
+		} else {
+			log.SetFlags(log.Ltime)
+		}
+	case "utc":
+		log.SetOutput(utcLogWriter{w})
+		log.SetFlags(0)
+	case "none":
+		log.SetOutput(w)
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("Unknown log timestamp format %q, expected local, utc or none", format)
+	}
+	return nil
+}
@@ -1,187 +1,1558 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Songmu/prompter"
 	"github.com/buildkite/cli/git"
 	"github.com/buildkite/cli/graphql"
 	"github.com/fatih/color"
 	"github.com/google/go-github/v25/github"
-	"golang.org/x/oauth2"
+	"golang.org/x/crypto/ed25519"
 )
 
 const (
-	githubRepositoryProvider = `RepositoryProviderGithub`
+	githubRepositoryProvider           = `RepositoryProviderGithub`
+	githubEnterpriseRepositoryProvider = `RepositoryProviderGithubEnterprise`
 )
 
 func main() {
-	org := flag.String("buildkite-org", "", "The buildkite organization")
+	org := flag.String("buildkite-org", "", "The buildkite organization, or several separated by commas to rotate in one run")
 	graphqlToken := flag.String("graphql-token", "", "A graphql token")
 	githubToken := flag.String("github-token", "", "A GitHub personal access token")
+	graphqlReadToken := flag.String("graphql-read-token", "", "A read-only graphql token for listing pipelines, leaving --graphql-token for rotating")
+	githubReadToken := flag.String("github-read-token", "", "A read-only GitHub token for listing hooks, leaving --github-token for editing them")
+	restToken := flag.String("rest-token", "", "A Buildkite REST API token to list pipelines with, for tokens without GraphQL access")
+	graphqlTokenFile := flag.String("graphql-token-file", "", "A file to read the graphql token from")
+	githubTokenFile := flag.String("github-token-file", "", "A file to read the GitHub personal access token from")
 	prompt := flag.Bool("prompt", true, "Whether to prompt before each rotate")
+	skipPermissionTest := flag.Bool("skip-permission-test", false, "Skip test updating a hook to its current value before rotating")
+	skipPreflight := flag.Bool("skip-preflight", false, "Skip checking webhook.buildkite.com and the GitHub APIs can be reached before rotating")
+	var preflightHosts stringSliceFlag
+	flag.Var(&preflightHosts, "preflight-host", "Another host to check can be reached before rotating, like a proxy (can be repeated)")
+	simulate := flag.Bool("simulate", false, "Run against a built-in demo organization rather than a real one, for learning the tool and testing wrappers")
+	assumeClean := flag.Bool("assume-clean", false, "Skip permission tests, reading hooks before editing them and other checks, for the fastest rotation of well-maintained orgs")
+	backupDir := flag.String("backup-dir", ".", "A directory to back up hook configs to before they are edited, empty to disable")
+	logFile := flag.String("log-file", "", "A file to write logs to as well as stderr, which is rotated as it grows")
+	logMaxSize := flag.Int64("log-max-size", 10, "The size in megabytes to rotate the --log-file at")
+	logMaxAge := flag.Duration("log-max-age", 24*time.Hour, "How long to write to a --log-file before rotating it, 0 to only rotate by size")
+	logMaxBackups := flag.Int("log-max-backups", 5, "How many rotated log files to keep")
+	logTimestamps := flag.String("log-timestamps", "local", "How to timestamp log lines, either local, utc for RFC3339 in UTC, or none")
+	githubActions := flag.Bool("github-actions", os.Getenv("GITHUB_ACTIONS") == "true", "Write step outputs, a job summary and failure annotations when running in GitHub Actions")
+	buildkiteMetaDataFlag := flag.Bool("buildkite-meta-data", true, "Write a summary of the run to build meta-data when running in a Buildkite job")
+	resultsStream := flag.String("results-stream", "", "A file or named pipe to write a json line to as each pipeline is processed")
+	reportFile := flag.String("report-file", "", "A file to write a json report of the run to")
+	reportTemplateFile := flag.String("report-template", "", "A go text/template to render the run report with, e.g change-ticket.md.tmpl")
+	reportTemplateOutput := flag.String("report-template-output", "", "A file to write the rendered --report-template to, defaulting to its name without .tmpl")
+	reportDest := flag.String("report-dest", "", "A destination like s3://bucket/prefix or gs://bucket/prefix to upload reports and backups to")
+
+	verifyAuditEvents := flag.Bool("verify-audit-events", false, "Confirm rotations were recorded in the Buildkite audit log and include the event ids in the report")
+	verifyGithubAuditEvents := flag.Bool("verify-github-audit-events", false, "Confirm hook updates were recorded in the GitHub organization audit log and include the event ids in the report")
+	auditLogFile := flag.String("audit-log", "", "A file to append a tamper-evident log of rotations to")
+	auditSigningKey := flag.String("audit-signing-key", "", "A file with a base64 ed25519 private key to sign audit log entries with")
+	auditPublicKey := flag.String("audit-public-key", "", "A file with a base64 ed25519 public key to verify audit log signatures with")
+	eventBus := flag.String("eventbridge-bus", "", "An AWS EventBridge event bus to publish an event to for each rotation")
+	datadogAPIKey := flag.String("datadog-api-key", "", "A Datadog API key to post an event for each rotation and failure with, also read from DD_API_KEY")
+	datadogSite := flag.String("datadog-site", firstNonEmpty(os.Getenv("DD_SITE"), "datadoghq.com"), "The Datadog site to post events to, like datadoghq.eu")
+	teamsWebhook := flag.String("teams-webhook", "", "A Microsoft Teams incoming webhook url to post a summary of the run to, also read from GWR_TEAMS_WEBHOOK")
+
+	githubAppID := flag.Int64("github-app-id", 0, "Authenticate as a github app with this id, using its installations on github.com")
+	githubAppKey := flag.String("github-app-key", "", "A file with the PEM private key of the github app")
+	policyFile := flag.String("policy-file", "", "A json file of pipelines and repositories that must never be rotated, or the only ones that can be")
+	regoPolicyFile := flag.String("rego-policy", "", "A rego policy to evaluate with opa before each rotation, which can deny it or require confirmation")
+	regoQuery := flag.String("rego-query", "data.buildkite.rotation", "The query of the rego policy that decides on each rotation")
+	githubTokensFile := flag.String("github-tokens", "", "A json file mapping github owners or repositories to the tokens to use for them")
+
+	var githubHostTokens stringSliceFlag
+	flag.Var(&githubHostTokens, "github-host", "A github enterprise server host and its token, like github.example.com=TOKEN (can be repeated)")
+	var githubAPIURLs stringSliceFlag
+	flag.Var(&githubAPIURLs, "github-api-url", "A github enterprise server host and its api url if it isn't https://host/api/v3/, like ghe.internal=https://ghe.internal:8443/api/v3/ (can be repeated)")
+	var urlRewriteFlags stringSliceFlag
+	flag.Var(&urlRewriteFlags, "url-rewrite", "Rewrite the start of repository remotes like git's insteadOf, e.g git@git.example.com:=git@github.com: (can be repeated)")
+	var hostAliasFlags stringSliceFlag
+	flag.Var(&hostAliasFlags, "host-alias", "A host used in repository remotes and the github host it refers to, like github-work=github.com (can be repeated)")
+	sshConfig := flag.String("ssh-config", defaultSSHConfigPath(), "An ssh config to resolve host aliases in repository remotes from, or empty to not use one")
+
+	var encryptTo stringSliceFlag
+	flag.Var(&encryptTo, "encrypt-to", "An armored openpgp public key file to encrypt backups and reports to (can be repeated)")
+
+	fixContentType := flag.Bool("fix-content-type", false, "Switch hooks with form payloads to json while rotating")
+	verifyPing := flag.Bool("verify-ping", false, "Ping each updated hook and check Buildkite accepted the delivery")
+	rollbackPing := flag.Bool("rollback-on-failed-ping", false, "Restore a hook's previous config if Buildkite doesn't accept the ping")
+	verifyFor := flag.Duration("verify-for", 0, "How long to watch deliveries to updated hooks after rotating, e.g 30m")
+	watchDrift := flag.Bool("watch", false, "Keep auditing after list or reconcile, alerting on drift and unknown hooks as they appear")
+	auditInterval := flag.Duration("audit-interval", 15*time.Minute, "How often to audit webhooks again with --watch")
+	alertEvery := flag.Duration("alert-every", 24*time.Hour, "How often to alert again about the same problem while watching, 0 to only alert once")
+	watchInterval := flag.Duration("watch-interval", time.Minute, "How often to check deliveries while watching")
+	confirmBuild := flag.Duration("confirm-build", 0, "How long to wait for a webhook triggered build of each rotated pipeline, e.g 10m")
+	alignEvents := flag.Bool("align-events", false, "Subscribe hooks to the events their pipeline builds from while rotating")
+	reactivate := flag.Bool("reactivate", false, "Re-activate inactive hooks while rotating")
+	fixInsecureSSL := flag.Bool("fix-insecure-ssl", false, "Turn on SSL verification for hooks that skip it while rotating")
+	force := flag.Bool("force", false, "Rotate buildkite webhooks even when no github hooks refer to them")
 	pipeline := flag.String("pipeline", "", "A specific pipeline slug to rotate")
+	pipelineID := flag.String("pipeline-id", "", "A specific pipeline to rotate by its graphql id, which doesn't need --buildkite-org")
+	cluster := flag.String("cluster", "", "Only rotate pipelines in the cluster with this name or uuid")
+	cacheDir := flag.String("cache-dir", defaultCacheDir(), "A directory to cache pipeline listings in")
+	cacheTTL := flag.Duration("cache-ttl", 0, "How long to use cached pipeline listings for, e.g 15m, zero to disable caching")
+	etagCache := flag.Bool("etag-cache", false, "Cache GitHub responses in the cache directory and revalidate them with conditional requests")
+	retryFrom := flag.String("retry-from", "", "The report of an earlier run to retry only the failed pipelines from, finishing the hook updates of those already rotated")
+	hookRetries := flag.Int("hook-retries", 0, "How many times to retry a failed hook update before leaving it for a follow-up run and moving on")
+	maxGithubFailures := flag.Int("max-github-failures", 3, "Stop rotating once this many GitHub hook updates have failed in a row, 0 to keep going")
+	githubRetries := flag.Int("github-retries", 3, "How many times to retry GitHub API requests that fail with a network error or a 502, 503 or 504")
+	githubBackoff := flag.Duration("github-backoff", time.Second, "How long to wait before the first retry of a GitHub API request, doubling each time")
+	githubTimeout := flag.Duration("github-timeout", 30*time.Second, "How long to wait for each GitHub API request, 0 for no limit")
+	graphqlRetries := flag.Int("graphql-retries", 3, "How many times to retry Buildkite API queries that fail with a network error or a 502, 503 or 504")
+	graphqlBackoff := flag.Duration("graphql-backoff", 2*time.Second, "How long to wait before the first retry of a Buildkite API query, doubling each time")
+	graphqlTimeout := flag.Duration("graphql-timeout", time.Minute, "How long to wait for each Buildkite API request, 0 for no limit. Rotating a webhook is never timed out")
+	concurrency := flag.Int("concurrency", 8, "How many repositories to list webhooks for at a time")
+	lastDelivery := flag.Bool("last-delivery", false, "Look up when each hook was last delivered to, with a request per hook")
+	auditProviderSettings := flag.Bool("provider-settings", false, "Look up each pipeline's provider settings and flag hooks that are inconsistent with them")
+	offline := flag.Bool("offline", false, "Plan from the inventory cached by the last run, without calling any APIs")
+	plannedByFlag := flag.String("planned-by", "", "The GitHub login to record as the planner of an offline plan")
+	refresh := flag.Bool("refresh", false, "List pipelines again rather than using a cached listing")
+	includeArchived := flag.Bool("include-archived", false, "Include archived pipelines, which are skipped by default")
+	sortBy := flag.String("sort", "", "The order to process pipelines in, either slug, repo, last-build or webhook-age")
+	groupBy := flag.String("group-by", "pipeline", "How to group pipelines in the output, either pipeline or repo")
+	format := flag.String("format", "json", "The output format for the list command, either json, csv, terraform or hooks")
+	inventoryFile := flag.String("inventory", "", "A previously exported json inventory for the reconcile command")
+	postStatus := flag.Bool("post-status", false, "Post a commit status on the default branch of each updated repository")
+	openIssues := flag.Bool("open-issues", false, "Open an issue describing the manual fix when a repository hook can't be updated")
+	issuesRepo := flag.String("issues-repo", "", "A central repository (org/name, or host/org/name on github enterprise) to open issues in, rather than the affected repository")
+	planFile := flag.String("plan-file", "rotation-plan.json", "The plan file written by the plan command and read by the approve and apply commands")
+	requireApproval := flag.Bool("require-approval", true, "Whether the apply command requires the plan to be approved by a second operator")
+	approvalSigningKey := flag.String("approval-signing-key", "", "A file with the approver's base64 ed25519 private key to sign approvals of plans with")
+	approverKeysFile := flag.String("approver-keys", "", "A file of the GitHub logins and base64 ed25519 public keys of operators who can approve plans, one per line")
+	slackApproval := flag.Bool("slack-approval", false, "Post the rotation plan to slack and wait for it to be approved before rotating")
+	slackToken := flag.String("slack-token", "", "A slack bot token with chat:write, used for approvals and delivery alerts")
+	slackChannel := flag.String("slack-channel", "", "The slack channel to post approvals and delivery alerts to")
+	slackSigningSecret := flag.String("slack-signing-secret", "", "The slack app signing secret, used to verify approvals")
+	slackListen := flag.String("slack-listen", ":3000", "The address to listen on for slack interactivity requests")
+	slackApprovalTimeout := flag.Duration("slack-approval-timeout", time.Hour, "How long to wait for approval in slack")
+	gitopsRepo := flag.String("gitops-repo", "", "A config repository (org/name) to open a pull request against with the new webhook urls")
+
+	checkUpdate := flag.Bool("check-update", true, "Check for a newer release on startup")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
+	plain := flag.Bool("plain", false, "Print plain ascii output without emoji, symbols or colors")
+	cronjob := flag.Bool("cronjob", false, "Run unattended on a schedule, without prompts, stopping cleanly when terminated and resuming on the next run")
+	stateDir := flag.String("state-dir", "", "A directory like a mounted volume to keep checkpoints, caches and the result file in between runs")
+
+	var tags stringSliceFlag
+	flag.Var(&tags, "tag", "Only rotate pipelines with a Buildkite tag (can be repeated, pipelines need every tag)")
+
+	var gitopsPaths stringSliceFlag
+	flag.Var(&gitopsPaths, "gitops-path", "A templated path in the config repository that refers to webhook urls, e.g pipelines/{{.Slug}}.tf (can be repeated)")
+
+	// an optional command can precede the flags, defaulting to rotate
+	args := os.Args[1:]
+	command := "rotate"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && !isPipelineURL(args[0]) {
+		command, args = args[0], args[1:]
+	}
+
+	// a pipeline url can be given before or after the flags
+	var pipelineURL string
+	if len(args) > 0 && isPipelineURL(args[0]) {
+		pipelineURL, args = args[0], args[1:]
+	}
+
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() > 0 && pipelineURL == "" {
+		pipelineURL = flag.Arg(0)
+	}
+
+	var logOutput io.Writer = os.Stderr
+	if *logFile != "" {
+		w, err := openRotatingLog(*logFile, *logMaxSize*1024*1024, *logMaxAge, *logMaxBackups)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error opening log file: %v"), err)
+		}
+		logOutput = io.MultiWriter(os.Stderr, w)
+	}
+	if *plain {
+		stdout = plainWriter{os.Stdout}
+		logOutput = plainWriter{logOutput}
+		color.NoColor = true
+	}
+	if err := setLogTimestamps(*logTimestamps, logOutput, *logFile != ""); err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+
+	// cronjobs have nobody to answer prompts, and keep everything in the state directory
+	if *cronjob {
+		*prompt = false
+		*checkUpdate = false
+	}
+	// simulations run against fixtures, so there's nothing to authenticate, reach or cache
+	if *simulate {
+		if *org != "" && *org != simulatedOrg {
+			fatalf(color.RedString("🚨 Simulations run against the %s organization, not %s"), simulatedOrg, *org)
+		}
+		*org = simulatedOrg
+		*graphqlToken, *githubToken = "simulated", "simulated"
+		*graphqlReadToken, *githubReadToken, *restToken = "", "", ""
+		*githubAppID = 0
+		*checkUpdate = false
+		*skipPreflight = true
+		if !flagSet("cache-dir") {
+			*cacheDir = ""
+		}
+		log.Printf(color.YellowString("⚠️  Simulating with the built-in %s organization, nothing real will be changed", simulatedOrg))
+	}
+
+	// well-maintained orgs can go straight to rotating and updating hooks
+	if *assumeClean {
+		*skipPermissionTest = true
+	}
+	if *stateDir != "" {
+		if *reportFile == "" {
+			*reportFile = filepath.Join(*stateDir, "result.json")
+		}
+		if !flagSet("cache-dir") {
+			*cacheDir = filepath.Join(*stateDir, "cache")
+		}
+		// backups have webhook urls in them, and the working directory may not be writable
+		if !flagSet("backup-dir") {
+			*backupDir = filepath.Join(*stateDir, "backups")
+		}
+	}
+
+	// cached listings, inventories and github responses have webhook urls in them, and can't
+	// be read back once they're encrypted, so nothing is cached when artifacts are encrypted
+	if len(encryptTo) > 0 && *cacheDir != "" {
+		if *offline {
+			fatalf(color.RedString("🚨 Planning --offline needs the cached inventory, which isn't kept with --encrypt-to"))
+		}
+		if *cacheTTL > 0 || *etagCache {
+			log.Printf(color.YellowString("⚠️  Not caching with --encrypt-to, since caches would have webhook urls in plain text"))
+		}
+		*cacheDir = ""
+	}
+
+	// every api client builds on the default transport, which retries and times out requests
+	// with the policy for the api they're to
+	var base http.RoundTripper = http.DefaultTransport
+	if *simulate {
+		base = newSimulation()
+	}
+	authFailures.base = &retryTransport{
+		base:      base,
+		github:    apiPolicy{name: "GitHub", retries: *githubRetries, backoff: *githubBackoff, timeout: *githubTimeout},
+		buildkite: apiPolicy{name: "Buildkite", retries: *graphqlRetries, backoff: *graphqlBackoff, timeout: *graphqlTimeout},
+	}
+
+	if *showVersion || command == "version" {
+		fmt.Fprintf(stdout, "github-webhook-rotate %s\n", versionString())
+		return
+	}
+
+	// the diff command compares two inventory exports, like audit snapshots, offline
+	if command == "diff" {
+		if flag.NArg() != 2 {
+			fatalf(color.RedString("🚨 The diff command compares two inventories, e.g diff before.json after.json"))
+		}
+		before, err := readInventory(flag.Arg(0))
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading inventory: %v"), err)
+		}
+		after, err := readInventory(flag.Arg(1))
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading inventory: %v"), err)
+		}
+		if before.Organization != after.Organization {
+			log.Printf(color.YellowString("⚠️  Comparing inventories of different organizations, %s and %s",
+				before.Organization, after.Organization))
+		}
+
+		fmt.Fprintf(stdout, "Comparing %s inventory from %s with %s\n\n", after.Organization,
+			before.GeneratedAt.Format(time.RFC3339), after.GeneratedAt.Format(time.RFC3339))
+
+		diff := diffInventories(before.Records, after.Records)
+		if diff.empty() {
+			fmt.Fprintf(stdout, color.GreenString("No differences found ✅\n"))
+			return
+		}
+
+		printInventoryDiff(stdout, diff)
+		os.Exit(exitDrift)
+	}
+
+	if *checkUpdate {
+		checkForUpdate(context.Background())
+	}
+
+	// tokens are taken from the first of the flag, a file, the environment or the keychain
+	// that has one. When both are piped in on stdin, the graphql token is the first line.
+	stdin := bufio.NewReader(os.Stdin)
+	for _, token := range []struct {
+		name    string
+		value   *string
+		sources tokenSources
+	}{
+		{"graphql-token", graphqlToken, tokenSources{
+			Flag:            *graphqlToken,
+			File:            *graphqlTokenFile,
+			Env:             []string{"GWR_GRAPHQL_TOKEN", "BUILDKITE_GRAPHQL_TOKEN"},
+			KeychainAccount: "graphql-token",
+		}},
+		{"github-token", githubToken, tokenSources{
+			Flag:            *githubToken,
+			File:            *githubTokenFile,
+			Env:             []string{"GWR_GITHUB_TOKEN", "GH_TOKEN", "GITHUB_TOKEN"},
+			KeychainAccount: "github-token",
+		}},
+		{"graphql-read-token", graphqlReadToken, tokenSources{
+			Flag:            *graphqlReadToken,
+			Env:             []string{"GWR_GRAPHQL_READ_TOKEN"},
+			KeychainAccount: "graphql-read-token",
+		}},
+		{"github-read-token", githubReadToken, tokenSources{
+			Flag:            *githubReadToken,
+			Env:             []string{"GWR_GITHUB_READ_TOKEN"},
+			KeychainAccount: "github-read-token",
+		}},
+	} {
+		if token.sources.Flag == "-" && *prompt && (command == "rotate" || command == "apply") {
+			fatalf(color.RedString("🚨 Reading --%s from stdin requires --prompt=false"), token.name)
+		}
+		value, err := token.sources.resolve(stdin)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading --%s: %v"), token.name, err)
+		}
+		*token.value = value
+	}
+
+	// commands that don't change anything only need the read-only tokens, though pinging
+	// hooks to verify them needs one that can edit them
+	if command != "rotate" && command != "apply" {
+		*graphqlToken = firstNonEmpty(*graphqlToken, *graphqlReadToken)
+		if command != "verify" {
+			*githubToken = firstNonEmpty(*githubToken, *githubReadToken)
+		}
+	}
+
+	// for casual interactive use, ask for any tokens that weren't provided
+	if *graphqlToken == "" && *restToken == "" && !*offline && command != "verify-audit-log" && command != "watch" {
+		*graphqlToken = promptToken("Buildkite GraphQL token")
+	}
+	if *githubToken == "" && *githubAppID == 0 && !*offline && command != "verify-audit-log" {
+		*githubToken = promptToken("GitHub token")
+	}
+
+	if pipelineURL != "" {
+		urlOrg, urlSlug, err := parsePipelineURL(pipelineURL)
+		if err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+		inOrg := *org == ""
+		for _, o := range splitOrganizations(*org) {
+			inOrg = inOrg || o == urlOrg
+		}
+		if !inOrg {
+			fatalf(color.RedString("🚨 %s isn't in the --buildkite-org %s"), pipelineURL, *org)
+		}
+		*org, *pipeline = urlOrg, urlSlug
+	}
 
-	flag.Parse()
-	log.SetFlags(log.Ltime)
+	if len(splitOrganizations(*org)) > 1 && *pipelineID != "" {
+		fatalf(color.RedString("🚨 --pipeline-id rotates a single pipeline, so it needs a single --buildkite-org"))
+	}
+
+	if _, err := path.Match(*pipeline, ""); err != nil {
+		fatalf(color.RedString("🚨 Invalid --pipeline pattern %q: %v"), *pipeline, err)
+	}
+
+	if *watchDrift && command != "list" && command != "reconcile" {
+		fatalf(color.RedString("🚨 Only the list and reconcile commands can --watch for drift"))
+	}
+
+	if *retryFrom != "" && command != "rotate" {
+		fatalf(color.RedString("🚨 Only the rotate command can --retry-from an earlier run"))
+	}
+
+	if *concurrency < 1 {
+		fatalf(color.RedString("🚨 --concurrency needs to be at least 1"))
+	}
+
+	var policy *rotationPolicy
+	if *policyFile != "" {
+		var err error
+		if policy, err = readRotationPolicy(*policyFile); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+
+	var rego *regoPolicy
+	if *regoPolicyFile != "" {
+		rego = &regoPolicy{path: *regoPolicyFile, query: *regoQuery}
+	}
+
+	if *offline && command != "plan" {
+		fatalf(color.RedString("🚨 Only the plan command can run --offline"))
+	} else if *offline && *plannedByFlag == "" {
+		fatalf(color.RedString("🚨 Offline plans need --planned-by, since the planner can't be looked up"))
+	} else if *offline && *org == "" {
+		fatalf(color.RedString("🚨 Offline plans need --buildkite-org to find the cached inventory, even with --pipeline-id"))
+	}
+
+	switch command {
+	case "rotate", "plan", "apply":
+		if *graphqlToken == "" && command != "plan" {
+			fatalf(color.RedString("🚨 Rotating webhooks requires a --graphql-token, the REST API can only list pipelines"))
+		}
+		if *gitopsRepo != "" && len(gitopsPaths) == 0 {
+			fatalf(color.RedString("🚨 A --gitops-repo requires at least one --gitops-path"))
+		}
+		if *slackApproval && (*slackToken == "" || *slackChannel == "" || *slackSigningSecret == "") {
+			fatalf(color.RedString("🚨 Slack approval requires --slack-token, --slack-channel and --slack-signing-secret"))
+		}
+		if command == "apply" && *requireApproval && *approverKeysFile == "" {
+			fatalf(color.RedString("🚨 Applying an approved plan requires --approver-keys to check the approval's signature"))
+		}
+	case "list", "verify":
+	case "reconcile":
+		if *inventoryFile == "" {
+			fatalf(color.RedString("🚨 The reconcile command requires --inventory"))
+		}
+	case "approve":
+		if *approvalSigningKey == "" {
+			fatalf(color.RedString("🚨 The approve command requires an --approval-signing-key to sign the approval with"))
+		}
+	case "watch":
+		if *reportFile == "" {
+			fatalf(color.RedString("🚨 The watch command requires the --report-file of a run"))
+		}
+		if *verifyFor == 0 {
+			*verifyFor = 30 * time.Minute
+		}
+	case "verify-audit-log":
+		if *auditLogFile == "" {
+			fatalf(color.RedString("🚨 The verify-audit-log command requires --audit-log"))
+		}
+	default:
+		fatalf(color.RedString("🚨 Unknown command %q"), command)
+	}
+
+	// the verify-audit-log command checks the chain and signatures of an audit log offline
+	if command == "verify-audit-log" {
+		var publicKey ed25519.PublicKey
+		if *auditPublicKey != "" {
+			var err error
+			if publicKey, err = readAuditPublicKey(*auditPublicKey); err != nil {
+				fatalf(color.RedString("🚨 %v"), err)
+			}
+		}
+
+		count, err := verifyAuditLog(*auditLogFile, publicKey)
+		if err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+
+		fmt.Fprintf(stdout, color.GreenString("Verified %d audit log entries ✅\n"), count)
+		return
+	}
 
 	ctx := context.Background()
 
 	// set up a client for buildkite's graphql api
 	client, err := graphql.NewClient(*graphqlToken)
 	if err != nil {
-		log.Fatal(err)
+		fatalf("%v", err)
+	}
+
+	// remotes can use host aliases from an ssh config, like git@github-work:org/repo.git
+	if err := addHostAliases(hostAliasFlags); err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+	if err := addURLRewrites(urlRewriteFlags); err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+	if *sshConfig != "" {
+		if err := readSSHConfigAliases(*sshConfig); err != nil && !os.IsNotExist(err) {
+			log.Printf(color.YellowString("⚠️  Failed to read host aliases from %s: %v", *sshConfig, err))
+		}
+	}
+
+	// set up clients for github's api, requires keys with `admin:repo_hook`
+	ghClients, err := newGithubClients(ctx, *githubToken, githubHostTokens)
+	if err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+	if err := ghClients.addAPIURLs(githubAPIURLs); err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+	if *etagCache && *cacheDir != "" {
+		ghClients.etagDir = filepath.Join(*cacheDir, "github")
+	}
+	if *githubTokensFile != "" {
+		if err := ghClients.readOwnerTokens(*githubTokensFile); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+	if *githubAppID != 0 && !*offline {
+		if ghClients.app, err = newGithubApp(ctx, *githubAppID, *githubAppKey); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+		if err = ghClients.app.discover(ctx); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+	ghClient := ghClients.defaultClient()
+	provider := &githubProvider{clients: ghClients, unchecked: *assumeClean}
+
+	// listing can use read-only tokens, so the write tokens are only used for changes
+	readClient, readProvider := client, provider
+	if *graphqlReadToken != "" {
+		if readClient, err = graphql.NewClient(*graphqlReadToken); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *githubReadToken != "" {
+		readProvider = &githubProvider{clients: ghClients.withDefaultToken(*githubReadToken)}
+	}
+
+	var alert func(string)
+	if *slackToken != "" && *slackChannel != "" {
+		alert = slackAlerter(*slackToken, *slackChannel)
+	}
+
+	// the watch command keeps an eye on deliveries to the hooks updated by an earlier run
+	if command == "watch" {
+		report, err := readRunReport(*reportFile)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading report: %v"), err)
+		}
+
+		watcher := &deliveryWatcher{
+			ghClients: ghClients,
+			hooks:     updatedHooks(report),
+			since:     report.StartedAt,
+			interval:  *watchInterval,
+			alert:     newAlertThrottle(alert, *alertEvery).send,
+		}
+		if failing := watcher.watch(ctx, *verifyFor); failing > 0 {
+			fatalf(color.RedString("🚨 Deliveries failed for %d hooks"), failing)
+		}
+
+		fmt.Fprintf(stdout, color.GreenString("No failed deliveries ✅\n"))
+		return
+	}
+
+	// the approve command records a second operator's approval of a plan
+	if command == "approve" {
+		plan, err := readRotationPlan(*planFile)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading plan: %v"), err)
+		}
+
+		if err = plan.verify(); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+
+		key, err := readAuditSigningKey(*approvalSigningKey)
+		if err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+
+		approvedBy, err := githubLogin(ctx, ghClient)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error identifying github user: %v"), err)
+		}
+
+		printRotationPlan(stdout, plan)
+		fmt.Fprintln(stdout)
+
+		if *prompt && !prompter.YN(fmt.Sprintf("Approve plan as %s?", approvedBy), false) {
+			return
+		}
+
+		if err = plan.approve(approvedBy, key); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+
+		if err = writeRotationPlan(*planFile, plan); err != nil {
+			fatalf(color.RedString("🚨 Error writing plan: %v"), err)
+		}
+
+		log.Printf("Plan approved by %s", approvedBy)
+		return
+	}
+
+	// the apply command only rotates the pipelines in an approved plan
+	var approvedPlan *rotationPlan
+	if command == "apply" {
+		approvedPlan, err = readRotationPlan(*planFile)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading plan: %v"), err)
+		}
+
+		if *requireApproval {
+			var approvers approverKeys
+			if approvers, err = readApproverKeys(*approverKeysFile); err == nil {
+				err = approvedPlan.verifyApproval(approvers)
+			}
+		} else {
+			err = approvedPlan.verify()
+		}
+		if err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+
+		*org = approvedPlan.Organization
+	}
+
+	// retrying an earlier run only goes back over the pipelines that failed in it
+	var retryPipelines map[string]pipelineResult
+	if *retryFrom != "" {
+		previous, err := readRunReport(*retryFrom)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading report: %v"), err)
+		}
+		if retryPipelines = failedPipelines(previous); len(retryPipelines) == 0 {
+			log.Printf("No pipelines failed in the run started at %s", previous.StartedAt.Format(time.RFC3339))
+			os.Exit(exitNothingToDo)
+		}
+		if *org == "" {
+			*org = previous.Organization
+		}
+		log.Printf("Retrying %d pipelines that failed in the run started at %s", len(retryPipelines),
+			previous.StartedAt.Format(time.RFC3339))
+	}
+
+	// ---------------------------------------------------------
+	// build up a map of buildkite webhook -> (github repository + hook)
+
+	listPipelines := graphqlPipelineLister(readClient)
+	if *graphqlToken == "" && *restToken != "" {
+		listPipelines = restPipelineLister(*restToken)
+	}
+
+	var cache *pipelineCache
+	if *cacheTTL > 0 && *cacheDir != "" {
+		cache = &pipelineCache{dir: *cacheDir, ttl: *cacheTTL, refresh: *refresh}
+		listPipelines = cache.wrap(listPipelines)
+	}
+
+	filter := pipelineFilter{
+		ID:              *pipelineID,
+		Slug:            *pipeline,
+		Cluster:         *cluster,
+		Tags:            tags,
+		IncludeArchived: *includeArchived,
+	}
+
+	var inv *inventory
+	if *offline {
+		var fetchedAt time.Time
+		if inv, fetchedAt, err = (&pipelineCache{dir: *cacheDir}).loadInventory(*org, filter); err != nil {
+			fatalf(color.RedString("🚨 Error reading cached inventory, run with --cache-ttl first: %v"), err)
+		}
+		fmt.Fprintf(stdout, color.YellowString("⚠️  Planning offline from cached state, stale as of %s (%v ago)\n"),
+			fetchedAt.Format(time.RFC3339), time.Since(fetchedAt).Round(time.Minute))
+	} else {
+		if inv, err = discoverWebhooks(ctx, listPipelines, readProvider, *org, filter, *concurrency); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+		if cache != nil {
+			if err := cache.saveInventory(inv); err != nil {
+				log.Printf("Failed to cache inventory: %v", err)
+			}
+		}
+		if *lastDelivery {
+			inv.LastDeliveries = fetchLastDeliveries(ctx, ghClients, inv, *concurrency)
+			if failing := prioritizeFailing(inv); failing > 0 {
+				log.Printf(color.YellowString("⚠️  The last delivery failed for hooks of %d pipelines, they're listed first", failing))
+			}
+		}
+		if *auditProviderSettings {
+			inv.ProviderSettings = fetchProviderSettings(firstNonEmpty(*restToken, *graphqlToken), inv, *concurrency)
+			for _, r := range inv.records() {
+				if r.ProviderMismatch != "" {
+					log.Printf(color.YellowString("⚠️  %s: %s", r, r.ProviderMismatch))
+				}
+			}
+		}
+	}
+
+	// compare with the inventory the last run left behind, which rotating runs save once
+	// they've finished and other commands save straight away
+	var lastRun *pipelineCache
+	if *cacheDir != "" && !*offline {
+		lastRun = &pipelineCache{dir: *cacheDir}
+		if command == "rotate" || command == "apply" || command == "plan" {
+			if last, err := lastRun.loadLastRun(inv.Org, filter); err != nil {
+				log.Printf(color.YellowString("⚠️  Failed to read the last run: %v", err))
+			} else if last != nil {
+				printChangelog(stdout, last, inv)
+			}
+		}
+		if command != "rotate" && command != "apply" {
+			if err := lastRun.saveLastRun(inv, filter, nil, nil); err != nil {
+				log.Printf("Failed to save the inventory of this run: %v", err)
+			}
+		}
+	}
+
+	// show what a pattern matched, so nothing is rotated by a pattern that was too broad
+	if filter.hasWildcard() && command != "list" {
+		fmt.Fprintf(stdout, "--pipeline %q matched %d pipelines:\n", filter.Slug, len(inv.Pipelines))
+		for _, p := range inv.Pipelines {
+			fmt.Fprintf(stdout, "\thttps://buildkite.com/%s\n", p.String())
+		}
+		if command == "rotate" && *prompt && len(inv.Pipelines) > 0 && !prompter.YN("Continue with these pipelines?", true) {
+			log.Printf("Quitting")
+			return
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	// keep auditing after the first audit, as a monitor between rotations
+	monitor := &driftMonitor{
+		discover: func() (*inventory, error) {
+			return discoverWebhooks(ctx, listPipelines, readProvider, *org, filter, *concurrency)
+		},
+		interval: *auditInterval,
+		alerts:   newAlertThrottle(alert, *alertEvery),
+	}
+
+	// the verify command pings the hooks at their current webhooks, without rotating anything
+	if command == "verify" {
+		fmt.Fprintf(stdout, "Pinging the hooks of %d pipelines\n\n", len(inv.Pipelines))
+		if failed := printHookChecks(stdout, verifyCurrentHooks(ctx, ghClients, inv, *concurrency), alert); failed > 0 {
+			fatalf(color.RedString("🚨 Buildkite didn't accept pings to %d hooks"), failed)
+		}
+		fmt.Fprintf(stdout, color.GreenString("Buildkite accepted pings to every hook ✅\n"))
+		return
+	}
+
+	// the list command is read-only, it just outputs the inventory
+	if command == "list" {
+		if err := writeInventory(stdout, inv, *format); err != nil {
+			fatalf(color.RedString("🚨 Error writing inventory: %v"), err)
+		}
+		if *watchDrift {
+			monitor.run(inv.records())
+		}
+		return
+	}
+
+	// the reconcile command compares a previous list with the live state
+	if command == "reconcile" {
+		previous, err := readInventory(*inventoryFile)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error reading inventory: %v"), err)
+		}
+
+		fmt.Fprintf(stdout, "Reconciling %s inventory from %s\n\n",
+			previous.Organization, previous.GeneratedAt.Format(time.RFC3339))
+
+		diff := diffInventories(previous.Records, inv.records())
+		if diff.empty() {
+			fmt.Fprintf(stdout, color.GreenString("No drift found ✅\n"))
+		} else {
+			printInventoryDiff(stdout, diff)
+		}
+
+		if *watchDrift {
+			monitor.run(inv.records())
+		} else if !diff.empty() {
+			os.Exit(exitDrift)
+		}
+		return
+	}
+
+	// the plan command writes the pipelines in scope to a plan for approval
+	if command == "plan" {
+		plannedBy := *plannedByFlag
+		if !*offline {
+			if plannedBy, err = githubLogin(ctx, ghClient); err != nil {
+				fatalf(color.RedString("🚨 Error identifying github user: %v"), err)
+			}
+		}
+
+		plan := newRotationPlan(inv, plannedBy, policy)
+		if err = writeRotationPlan(*planFile, plan); err != nil {
+			fatalf(color.RedString("🚨 Error writing plan: %v"), err)
+		}
+
+		fmt.Fprintln(stdout)
+		printRotationPlan(stdout, plan)
+		fmt.Fprintln(stdout)
+
+		log.Printf("Wrote plan to %s, it needs to be approved by a second operator before it's applied", *planFile)
+		return
+	}
+
+	pipelines, repoHookMap := inv.Pipelines, inv.TokenHooks
+
+	pipelines, err = sortPipelines(pipelines, *sortBy, inv)
+	if err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+
+	pipelines, err = groupPipelines(pipelines, *groupBy)
+	if err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+
+	if retryPipelines != nil {
+		retrying := pipelines[:0:0]
+		for _, p := range pipelines {
+			if _, ok := retryPipelines[p.ID]; ok {
+				retrying = append(retrying, p)
+			}
+		}
+		if len(retrying) < len(retryPipelines) {
+			log.Printf(color.YellowString("⚠️  %d of the failed pipelines no longer exist or aren't in scope",
+				len(retryPipelines)-len(retrying)))
+		}
+		pipelines = retrying
+	}
+
+	if approvedPlan != nil {
+		if missing := approvedPlan.missing(pipelines); len(missing) > 0 {
+			fatalf(color.RedString("🚨 Planned pipelines no longer exist: %s"), strings.Join(missing, ", "))
+		}
+	}
+
+	// gate unattended runs on someone approving the plan in slack
+	if *slackApproval && command != "plan" {
+		requestedBy, err := githubLogin(ctx, ghClient)
+		if err != nil {
+			fatalf(color.RedString("🚨 Error identifying github user: %v"), err)
+		}
+
+		var summary bytes.Buffer
+		printRotationPlan(&summary, newRotationPlan(inv, requestedBy, policy))
+
+		approver := &slackApprover{
+			Token:         *slackToken,
+			Channel:       *slackChannel,
+			SigningSecret: *slackSigningSecret,
+			ListenAddr:    *slackListen,
+			Timeout:       *slackApprovalTimeout,
+		}
+
+		decision, err := approver.requestApproval(ctx, summary.String())
+		if err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+		if !decision.Approved {
+			fatalf(color.RedString("🚨 Rotation rejected in slack by %s"), decision.User)
+		}
+
+		log.Printf("Rotation approved in slack by %s", decision.User)
+	}
+
+	r := &rotator{
+		client:             client,
+		apiToken:           firstNonEmpty(*restToken, *graphqlToken),
+		ghClients:          ghClients,
+		provider:           provider,
+		skipPermissionTest: *skipPermissionTest,
+		postStatus:         *postStatus,
+		openIssues:         *openIssues,
+		issuesRepo:         *issuesRepo,
+		verifyPing:         *verifyPing,
+		rollbackPing:       *rollbackPing,
+		confirmBuild:       *confirmBuild,
+		testedRepos:        map[string]bool{},
+		maxGithubFailures:  *maxGithubFailures,
+		hookRetries:        *hookRetries,
+		fixes: hookFixes{
+			ContentType: *fixContentType,
+			InsecureSSL: *fixInsecureSSL,
+			Reactivate:  *reactivate,
+			AlignEvents: *alignEvents,
+		},
+	}
+
+	var dest artifactUploader
+	if *reportDest != "" {
+		if dest, err = newArtifactUploader(ctx, *reportDest); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+
+	artifacts, err := newArtifactWriter(encryptTo, dest)
+	if err != nil {
+		fatalf(color.RedString("🚨 %v"), err)
+	}
+
+	report := newRunReport(inv.Org)
+	for _, hook := range inv.UnparseableHooks {
+		report.UnparseableHooks = append(report.UnparseableHooks,
+			unparseableHookResult{hook.githubRepository.String(), hook.GetID(), hook.Reason})
+	}
+
+	if *resultsStream != "" {
+		if report.stream, err = openResultStream(*resultsStream); err != nil {
+			fatalf(color.RedString("🚨 Error opening results stream: %v"), err)
+		}
+	}
+
+	var audit *auditLog
+	if *auditLogFile != "" {
+		var key ed25519.PrivateKey
+		if *auditSigningKey != "" {
+			if key, err = readAuditSigningKey(*auditSigningKey); err != nil {
+				fatalf(color.RedString("🚨 %v"), err)
+			}
+		}
+		if audit, err = openAuditLog(*auditLogFile, key); err != nil {
+			fatalf(color.RedString("🚨 Error opening audit log: %v"), err)
+		}
+	}
+
+	var events *eventBridgePublisher
+	if *eventBus != "" {
+		if events, err = newEventBridgePublisher(*eventBus); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+
+	if *teamsWebhook == "" {
+		*teamsWebhook = os.Getenv("GWR_TEAMS_WEBHOOK")
+	}
+	if *datadogAPIKey == "" {
+		*datadogAPIKey = os.Getenv("DD_API_KEY")
+	}
+	var datadog *datadogPublisher
+	if *datadogAPIKey != "" {
+		datadog = &datadogPublisher{apiKey: *datadogAPIKey, site: *datadogSite}
+	}
+	publishers := &resultPublishers{audit: audit, events: events, datadog: datadog}
+
+	var reportTmpl *reportTemplate
+	if *reportTemplateFile != "" {
+		if *reportTemplateOutput == "" && !strings.HasSuffix(*reportTemplateFile, ".tmpl") {
+			fatalf(color.RedString("🚨 Templates without a .tmpl suffix need a --report-template-output"))
+		}
+		if reportTmpl, err = readReportTemplate(*reportTemplateFile); err != nil {
+			fatalf(color.RedString("🚨 %v"), err)
+		}
+	}
+
+	var rotations []rotation
+
+	// write the report and upload it along with any backups
+	publishArtifacts := func() {
+		if report.LegacyHooksRemaining = inv.legacyHooksRemaining(report); report.LegacyHooksRemaining > 0 {
+			log.Printf(color.YellowString("⚠️  %d hooks still point at webhook.buildbox.io", report.LegacyHooksRemaining))
+		}
+		report.Groups = inv.repositoryGroups(report)
+
+		if lastRun != nil {
+			if err := lastRun.saveLastRun(inv, filter, rotations, report); err != nil {
+				log.Printf("Failed to save the inventory of this run: %v", err)
+			}
+		}
+
+		if *buildkiteMetaDataFlag && inBuildkiteJob() {
+			if err := setBuildkiteMetaData(report); err != nil {
+				log.Printf(color.YellowString("⚠️  %v", err))
+			}
+		}
+		if *githubActions {
+			if err := writeGithubActions(stdout, report); err != nil {
+				log.Printf(color.YellowString("⚠️  %v", err))
+			}
+		}
+		if *teamsWebhook != "" {
+			if err := postTeamsSummary(*teamsWebhook, report); err != nil {
+				log.Printf(color.YellowString("⚠️  Failed to post summary to Microsoft Teams: %v", err))
+			}
+		}
+
+		data, err := report.marshal()
+		if err == nil && *reportFile != "" {
+			err = artifacts.writeFile(*reportFile, data)
+		} else if err == nil {
+			_, err = artifacts.store(report.filename(), data)
+		}
+		if err == nil && reportTmpl != nil {
+			if data, err = reportTmpl.render(report); err == nil {
+				err = artifacts.writeFile(firstNonEmpty(*reportTemplateOutput, reportTmpl.name), data)
+			}
+		}
+		if err == nil && *auditLogFile != "" {
+			if data, err = ioutil.ReadFile(*auditLogFile); err == nil {
+				_, err = artifacts.store(filepath.Base(*auditLogFile), data)
+			} else if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err == nil {
+			err = artifacts.upload(ctx)
+		}
+		if err != nil {
+			log.Printf(color.RedString("🚨 Error publishing report: %v", err))
+		}
+	}
+
+	if *backupDir != "" {
+		r.backup = newHookBackup(*backupDir, artifacts)
+	}
+
+	// ---------------------------------------------------------------
+	// iterate over pipelines and map webhook to github repositories
+
+	// rule out network problems before anything is rotated, so they aren't mistaken for
+	// credential problems
+	if !*skipPreflight {
+		hosts := append(append([]string{buildkiteWebhookHost}, ghClients.apiHosts()...), preflightHosts...)
+		report.Preflight = preflight(hosts)
+		unreachable := 0
+		for _, check := range report.Preflight {
+			if !check.Reachable {
+				unreachable++
+				fmt.Fprintf(stdout, color.RedString("🚨 Can't reach %s from this runner: %s\n"), check.Host, check.Error)
+			}
+		}
+		if unreachable > 0 {
+			publishArtifacts()
+			fatalf(color.RedString("🚨 %d hosts can't be reached, which is a network problem rather than a credential one. Check DNS, firewalls and proxies."), unreachable)
+		}
+	}
+
+	fmt.Fprintln(stdout)
+	printOverview(stdout, inv)
+	fmt.Fprintln(stdout)
+
+	// fine-grained tokens can be missing the webhooks permission for some repositories, find
+	// them all before anything is rotated
+	if !*skipPermissionTest {
+		if gaps := ghClients.checkWebhookPermissions(ctx, pipelines, repoHookMap); len(gaps) > 0 {
+			for repo, gap := range gaps {
+				fmt.Fprintf(stdout, color.RedString("🚨 Can't edit hooks in %s: %s\n"), githubURL(repo), gap)
+			}
+			fatalf(color.RedString("🚨 Fine-grained tokens need the Webhooks repository permission (read and write) for %d repositories"), len(gaps))
+		}
+	}
+
+	var rotateRemaining bool
+	var currentRepo string
+
+	// a cronjob that was terminated part way through leaves a checkpoint to resume from
+	var progress *checkpoint
+	if *stateDir != "" {
+		if progress, err = openCheckpoint(*stateDir, inv.Org); err != nil {
+			fatalf(color.RedString("🚨 Error opening checkpoint: %v"), err)
+		}
+		if len(progress.Completed) > 0 {
+			log.Printf("Resuming the run started at %s, %d pipelines already rotated",
+				progress.StartedAt.Format(time.RFC3339), len(progress.Completed))
+		}
+	}
+
+	// stop between pipelines when terminated, rather than part way through rotating one, so
+	// the run finishes within the termination grace period
+	terminated := make(chan os.Signal, 1)
+	if *cronjob {
+		signal.Notify(terminated, syscall.SIGTERM, os.Interrupt)
+	}
+	var interrupted bool
+
+	// groupErr stops the rest of a repository's pipelines being rotated once one of them
+	// can't be, so none of its hooks are left pointing at a revoked webhook
+	var groupErr error
+
+rotateLoop:
+	for _, pipeline := range pipelines {
+		var planned plannedPipeline
+		if approvedPlan != nil {
+			var ok bool
+			if planned, ok = approvedPlan.pipeline(pipeline.ID); !ok {
+				continue
+			}
+		}
+
+		select {
+		case sig := <-terminated:
+			log.Printf(color.YellowString("⚠️  Received %v, stopping before https://buildkite.com/%s", sig, pipeline.String()))
+			interrupted = true
+			break rotateLoop
+		default:
+		}
+
+		if r.tripped() {
+			log.Printf(color.RedString("🚨 %d GitHub hook updates failed in a row, stopping before https://buildkite.com/%s so its webhook isn't revoked",
+				r.githubFailures, pipeline.String()))
+			interrupted = true
+			break rotateLoop
+		}
+
+		if progress.completed(pipeline.ID) {
+			report.add(newPipelineResult(pipeline, outcomeSkipped, "rotated by an earlier run"))
+			continue
+		}
+
+		report.stream.start(pipeline)
+
+		// pipelines rotated by the earlier run only need their remaining hooks updated
+		if previous, ok := retryPipelines[pipeline.ID]; ok && (previous.WebhookRotated || previous.Outcome == outcomePartial) {
+			fmt.Fprintf(stdout, "Pipeline: https://buildkite.com/%s\n", pipeline.String())
+			fmt.Fprintf(stdout, "\tWebhook was rotated by the earlier run, updating the hooks that failed\n\n")
+			if *prompt && !prompter.YN("Update hooks?", true) {
+				report.add(newPipelineResult(pipeline, outcomeSkipped, "declined"))
+				continue
+			}
+
+			result := r.finishHooks(ctx, pipeline, previous)
+			report.add(result)
+			publishers.publish(ctx, pipeline, result)
+			if err := progress.complete(pipeline.ID); err != nil {
+				log.Printf(color.RedString("🚨 Error writing checkpoint: %v", err))
+			}
+
+			if failed := result.failedHooks(); failed > 0 {
+				fmt.Fprintf(stdout, color.YellowString("\n%d of %d hooks still need a manual fix ⚠️\n\n"), failed, len(result.Hooks))
+			} else {
+				fmt.Fprintf(stdout, color.GreenString("\nUpdated hooks ✅\n\n"))
+			}
+			continue
+		}
+
+		// show a heading for each repository with its pipelines nested beneath
+		if *groupBy == "repo" && pipeline.Repository.String() != currentRepo {
+			currentRepo = pipeline.Repository.String()
+			fmt.Fprintf(stdout, color.New(color.Bold).Sprintf("Repository: %s\n\n", githubURL(currentRepo)))
+
+			groupErr = nil
+			if group := inv.sharedBy(pipeline.Repository); len(group) > 1 {
+				fmt.Fprintf(stdout, "Shared by %d pipelines, which are rotated together\n\n", len(group))
+				if !*skipPermissionTest {
+					groupErr = r.testHooks(ctx, inv.groupMatches(group))
+				}
+			}
+		}
+
+		fmt.Fprintf(stdout, "Pipeline: http://buildkite.com/%s/%s\n", pipeline.Org, pipeline.Slug)
+		fmt.Fprintf(stdout, "\tCurrent Webhook: %s\n", pipeline.WebhookURL)
+		fmt.Fprintf(stdout, "\tRepository %s\n", pipeline.Repository.URL())
+		if len(pipeline.Teams) > 0 {
+			fmt.Fprintf(stdout, "\tTeams: %s\n", strings.Join(pipeline.Teams, ", "))
+		}
+		if len(pipeline.Tags) > 0 {
+			fmt.Fprintf(stdout, "\tTags: %s\n", strings.Join(pipeline.Tags, ", "))
+		}
+		if pipeline.Cluster != "" {
+			fmt.Fprintf(stdout, "\tCluster: %s\n", pipeline.Cluster)
+		}
+		if pipeline.Visibility != "" {
+			fmt.Fprintf(stdout, "\tVisibility: %s\n", pipeline.Visibility)
+		}
+
+		// lookup repositories that refer to this webhook token
+		matches, ok := repoHookMap[pipeline.WebhookToken]
+		if !ok {
+			fmt.Fprintf(stdout, color.YellowString("\t⚠️  No GitHub repositories with matching hooks\n"))
+		} else {
+			fmt.Fprintf(stdout, "\tGithub Repositories with matching Webhooks:\n")
+		}
+
+		// hooks can only point at one of the pipelines once the webhook is rotated
+		shared := inv.pipelinesWithToken(pipeline.WebhookToken)
+		if len(matches) > 0 && len(shared) > 1 {
+			fmt.Fprintf(stdout, color.YellowString("\t⚠️  These hooks also serve %d other pipelines with the same webhook:\n"), len(shared)-1)
+			for _, other := range shared {
+				if other.ID != pipeline.ID {
+					fmt.Fprintf(stdout, "\t\thttps://buildkite.com/%s\n", other.String())
+				}
+			}
+			fmt.Fprintf(stdout, color.YellowString("\t   Rotating any one of them points the hooks at its new webhook only, and the others stop\n"+
+				"\t   building on push. Give each pipeline its own hook before rotating.\n"))
+		}
+
+		fixes := r.fixes
+		if len(matches) > 0 && !*assumeClean {
+			fixes = r.fixesFor(pipeline)
+		}
+
+		// show repositories that match the pipeline webhook
+		for _, match := range matches {
+			fmt.Fprintf(stdout, "\t\t%s\n", match.githubRepository.URL())
+			printHookDiff(stdout, "\t\t\t", match, "", fixes)
+			for _, warning := range hookWarnings(match.Hook, fixes.Events) {
+				fmt.Fprintf(stdout, color.YellowString("\t\t\t⚠️  %s\n"), warning)
+			}
+		}
+
+		// show unknown webhooks for the repository
+		if unknown := inv.unknownHooks(pipeline.Repository); len(unknown) > 0 && !*assumeClean {
+			fmt.Fprintf(stdout, color.YellowString("\t⚠️  Unknown Buildkite hooks found\n"))
+			for _, hook := range unknown {
+				fmt.Fprintf(stdout, "\t\t%s\n", pipeline.Repository.URL())
+				fmt.Fprintf(stdout, "\t\t\t%s/settings/hooks/%d\n", pipeline.Repository.URL(), *hook.ID)
+				fmt.Fprintf(stdout, "\t\t\t\t%s\n", hookURL(hook))
+			}
+		}
+
+		// without any hooks to update there is nothing to rotate, unless they are managed elsewhere
+		if len(matches) == 0 && !*force {
+			fmt.Fprintf(stdout, "\tSkipping, use --force to rotate the buildkite webhook anyway\n\n")
+			report.add(newPipelineResult(pipeline, outcomeSkipped, "no matching hooks"))
+			continue
+		}
+
+		// the policy applies whatever else was asked for
+		if err := policy.check(pipeline, matches); err != nil {
+			fmt.Fprintf(stdout, color.YellowString("\tSkipping, %v\n\n"), err)
+			report.add(newPipelineResult(pipeline, outcomeSkipped, err.Error()))
+			continue
+		}
+
+		if rego != nil {
+			decision, err := rego.evaluate(newRegoInput(inv, pipeline, matches))
+			if err != nil {
+				publishArtifacts()
+				fatalf(color.RedString("🚨 %v"), err)
+			}
+			if len(decision.Deny) > 0 {
+				fmt.Fprintf(stdout, color.YellowString("\tSkipping, denied by policy: %s\n\n"), strings.Join(decision.Deny, ", "))
+				report.add(newPipelineResult(pipeline, outcomeSkipped, "denied by policy: "+strings.Join(decision.Deny, ", ")))
+				continue
+			}
+
+			// approved plans have already been confirmed by a second operator
+			if len(decision.Confirm) > 0 && approvedPlan == nil {
+				fmt.Fprintf(stdout, color.YellowString("\t⚠️  Policy requires confirmation: %s\n"), strings.Join(decision.Confirm, ", "))
+				if !*prompt || !prompter.YN("Rotate anyway?", false) {
+					fmt.Fprintf(stdout, "\tSkipping, not confirmed\n\n")
+					report.add(newPipelineResult(pipeline, outcomeSkipped, "not confirmed: "+strings.Join(decision.Confirm, ", ")))
+					continue
+				}
+			}
+		}
+
+		if len(matches) > 0 && len(shared) > 1 && !*force {
+			fmt.Fprintf(stdout, "\tSkipping, use --force to rotate the shared webhook anyway\n\n")
+			report.add(newPipelineResult(pipeline, outcomeSkipped, "hooks serve other pipelines"))
+			continue
+		}
+
+		if groupErr != nil {
+			fmt.Fprintf(stdout, color.RedString("\tSkipping, %v\n\n"), groupErr)
+			report.add(newPipelineResult(pipeline, outcomeFailed, groupErr.Error()))
+			continue
+		}
+
+		if approvedPlan != nil {
+			// the plan was approved, so it's only safe to apply to the same hooks
+			if err := planned.checkHooks(matches); err != nil {
+				report.add(newPipelineResult(pipeline, outcomeFailed, err.Error()))
+				publishArtifacts()
+				fatalf(color.RedString("🚨 %v"), err)
+			}
+
+			// don't clobber changes someone made to the hooks since they were planned
+			current, err := refetchHooks(ctx, readProvider, matches)
+			if err != nil {
+				err = fmt.Errorf("Error checking hooks for %s before applying: %v", pipeline.String(), err)
+				report.add(newPipelineResult(pipeline, outcomeFailed, err.Error()))
+				publishArtifacts()
+				fatalf(color.RedString("🚨 %v"), err)
+			}
+			if modified := planned.modifiedHooks(current); len(modified) > 0 {
+				for _, hook := range modified {
+					fmt.Fprintf(stdout, color.YellowString("⚠️  %s has been modified since the plan was generated\n"), hook)
+				}
+				if !*prompt || !prompter.YN("Apply the plan to the modified hooks anyway?", false) {
+					err := fmt.Errorf("Hooks for %s have been modified since the plan was generated", pipeline.String())
+					report.add(newPipelineResult(pipeline, outcomeFailed, err.Error()))
+					publishArtifacts()
+					fatalf(color.RedString("🚨 %v"), err)
+				}
+			}
+			matches = current
+		} else if *prompt && !rotateRemaining {
+			fmt.Fprintln(stdout)
+
+			answer := promptRotate(func() {
+				printHookDetails(stdout, pipeline, matches, inv.LastDeliveries)
+			})
+
+			switch answer {
+			case answerNo:
+				report.add(newPipelineResult(pipeline, outcomeSkipped, "declined"))
+				continue
+			case answerAll:
+				rotateRemaining = true
+			case answerSkipRemaining:
+				log.Printf("Skipping remaining pipelines")
+				break rotateLoop
+			case answerQuit:
+				log.Printf("Quitting")
+				publishArtifacts()
+				os.Exit(report.exitCode())
+			}
+		}
+
+		fmt.Fprintln(stdout)
+
+		// cached listings have the old webhook urls
+		if cache != nil {
+			cache.invalidate(inv.Org)
+		}
+
+		rotation, result, err := r.rotate(ctx, pipeline, matches, fixes)
+		report.add(result)
+		publishers.publish(ctx, pipeline, result)
+
+		if err != nil {
+			publishArtifacts()
+			log.Printf(color.RedString("🚨 %v"), err)
+			os.Exit(exitPartial)
+		}
+
+		if err := progress.complete(pipeline.ID); err != nil {
+			log.Printf(color.RedString("🚨 Error writing checkpoint: %v", err))
+		}
+
+		rotations = append(rotations, rotation)
+
+		if failed := result.failedHooks(); failed > 0 {
+			if *groupBy == "repo" && len(inv.sharedBy(pipeline.Repository)) > 1 {
+				groupErr = fmt.Errorf("https://buildkite.com/%s in the same repository needs a manual fix first", pipeline.String())
+			}
+			fmt.Fprintf(stdout, color.YellowString("\nUpdated webhook, %d of %d hooks need a manual fix ⚠️\n\n"),
+				failed, len(matches))
+			continue
+		}
+
+		fmt.Fprintf(stdout, color.GreenString("\nUpdated webhook ✅\n\n"))
+	}
+
+	// keep downstream config in sync with a pull request to the config repository
+	if *gitopsRepo != "" && len(rotations) > 0 {
+		log.Printf("Updating webhook urls in %s", githubURL(*gitopsRepo))
+
+		pr, err := openGitopsPullRequest(ctx, ghClient, *gitopsRepo, gitopsPaths, rotations)
+		if err != nil {
+			publishArtifacts()
+			fatalf(color.RedString("🚨 Error opening pull request: %v"), err)
+		}
+		if pr != nil {
+			log.Printf("Opened %s", pr.GetHTMLURL())
+		}
+	}
+
+	// independently confirm the rotations were recorded by buildkite
+	if *verifyAuditEvents && len(rotations) > 0 {
+		if err := crossCheckBuildkiteAuditEvents(client, report); err != nil {
+			log.Printf(color.YellowString("⚠️  Failed to cross-check the Buildkite audit log: %v", err))
+		}
+	}
+
+	if *verifyGithubAuditEvents && len(rotations) > 0 {
+		if err := crossCheckGithubAuditEvents(ctx, ghClients, report); err != nil {
+			log.Printf(color.YellowString("⚠️  Failed to cross-check the GitHub audit log: %v", err))
+		}
+	}
+
+	publishArtifacts()
+
+	if interrupted && progress != nil {
+		log.Printf("Stopped early, the next run will resume from the checkpoint")
+		os.Exit(exitPartial)
+	} else if interrupted {
+		log.Printf("Stopped early, use --state-dir to keep a checkpoint to resume from")
+		os.Exit(exitPartial)
+	}
+	if err := progress.clear(); err != nil {
+		log.Printf(color.YellowString("⚠️  Failed to remove checkpoint: %v", err))
 	}
 
-	// set up a client for github's api, requires a key with `admin:repo_hook`
-	ghClient := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: *githubToken},
-	)))
+	// catch breakage that only shows up once real events are delivered
+	if *verifyFor > 0 && len(rotations) > 0 {
+		watcher := &deliveryWatcher{
+			ghClients: ghClients,
+			hooks:     updatedHooks(report),
+			since:     report.StartedAt,
+			interval:  *watchInterval,
+			alert:     newAlertThrottle(alert, *alertEvery).send,
+		}
+		if failing := watcher.watch(ctx, *verifyFor); failing > 0 {
+			fatalf(color.RedString("🚨 Deliveries failed for %d hooks"), failing)
+		}
+	}
 
-	// ---------------------------------------------------------
-	// build up a map of buildkite webhook -> (github repository + hook)
+	os.Exit(report.exitCode())
+}
 
-	repoHookMap := map[string][]githubRepositoryHook{}
+// inventory is the mapping of buildkite pipelines to the github repository hooks
+// that refer to their webhooks
+type inventory struct {
+	Org       string
+	Pipelines []pipeline
 
-	log.Printf("Building a map of github repositories with buildkite webhooks for %s", *org)
+	// RepositoryHooks are the buildkite hooks found on each repository, keyed by org/name
+	RepositoryHooks map[string][]*github.Hook
 
-	pipelines, err := listGithubPipelines(client, *org, *pipeline)
-	if err != nil {
-		log.Fatalf(color.RedString("🚨 Error getting pipelines: %v"), err)
-	}
+	// TokenHooks are the repository hooks that refer to each webhook token
+	TokenHooks map[string][]githubRepositoryHook
 
-	repoHooks := map[string][]*github.Hook{}
+	// UnparseableHooks are the hooks whose urls can't be parsed, which are left alone
+	UnparseableHooks []unparseableHook
 
-	// iterate over all out pipelines
-	for _, pipeline := range pipelines {
-		// don't process repositories multiple times
-		if _, ok := repoHooks[pipeline.Repository.String()]; ok {
-			continue
-		}
+	// LastDeliveries are the most recent deliveries of the hooks, when they've been looked up
+	LastDeliveries hookDeliveries
 
-		log.Printf("Finding webhooks for https://github.com/%s", pipeline.Repository.String())
+	// ProviderSettings are the provider settings of the pipelines by id, when they've been looked up
+	ProviderSettings map[string]providerSettings
+}
 
-		hooks, err := getGithubRepositoryWebhooks(ctx, ghClient, pipeline.Repository)
-		if err != nil {
-			log.Fatalf(color.RedString("🚨 Error getting webhooks for https://buildkite.com/%s: %v"),
-				pipeline.String(), err)
+// unknownHooks returns the buildkite hooks on a repository that don't refer to any
+// of the pipelines in the inventory
+func (inv *inventory) unknownHooks(repo githubRepository) []*github.Hook {
+	unknown := []*github.Hook{}
+	for _, hook := range inv.RepositoryHooks[repo.String()] {
+		if !isHookReferencedInPipelines(hook, inv.Pipelines) {
+			unknown = append(unknown, hook)
 		}
+	}
+	return unknown
+}
 
-		// store all the matching webhooks in our map
-		for _, hook := range hooks {
-			hookURL := hook.Config["url"].(string)
+// pipelinesWithToken returns the pipelines that have a webhook token, which is normally
+// just one unless the webhook url was copied between pipelines
+func (inv *inventory) pipelinesWithToken(token string) []pipeline {
+	var found []pipeline
+	for _, p := range inv.Pipelines {
+		if p.WebhookToken == token {
+			found = append(found, p)
+		}
+	}
+	return found
+}
 
-			// extract just the token to allow format changes over time
-			hookToken, err := getWebhookToken(hookURL)
-			if err != nil {
-				log.Fatalf(color.RedString("🚨 Error parsing webhook: %v"), err)
+// legacyHooksRemaining counts the hooks pointing at webhook.buildbox.io that weren't updated
+func (inv *inventory) legacyHooksRemaining(report *runReport) int {
+	remaining := 0
+	for repo, hooks := range inv.RepositoryHooks {
+		for _, hook := range hooks {
+			if isLegacyHook(hook) && !report.updated(repo, *hook.ID) {
+				remaining++
 			}
+		}
+	}
+	return remaining
+}
 
-			if _, exists := repoHookMap[hookToken]; !exists {
-				repoHookMap[hookToken] = []githubRepositoryHook{
-					githubRepositoryHook{pipeline.Repository, hook},
-				}
-			} else {
-				repoHookMap[hookToken] = append(repoHookMap[hookToken],
-					githubRepositoryHook{pipeline.Repository, hook})
-			}
+// discoverWebhooks lists the pipelines in one or more organizations and the hooks of the
+// repositories they build, listing up to concurrency repositories at a time. Repositories
+// shared between organizations are only listed once, and their hooks are mapped to the
+// pipelines of every organization.
+func discoverWebhooks(ctx context.Context, listPipelines pipelineLister, provider repositoryProvider, org string, filter pipelineFilter, concurrency int) (*inventory, error) {
+	log.Printf("Building a map of github repositories with buildkite webhooks for %s", org)
+
+	var pipelines []pipeline
+	for _, o := range splitOrganizations(org) {
+		orgPipelines, err := listPipelines(o, filter)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting pipelines: %v", err)
 		}
+		pipelines = append(pipelines, orgPipelines...)
+	}
 
-		// track the hooks for this repository
-		repoHooks[pipeline.Repository.String()] = hooks
+	// a pipeline fetched by id knows its organization
+	if org == "" && len(pipelines) > 0 {
+		org = pipelines[0].Org
 	}
 
-	// ---------------------------------------------------------------
-	// iterate over pipelines and map webhook to github repositories
+	repoHooks := map[string][]*github.Hook{}
+	var repoHooksMu sync.Mutex
 
-	fmt.Println()
+	// list hooks for several repositories at a time, stopping at the first error
+	g := newBoundedGroup(ctx, concurrency)
+	queued := map[string]bool{}
 
 	for _, pipeline := range pipelines {
-		fmt.Printf("Pipeline: http://buildkite.com/%s/%s\n", pipeline.Org, pipeline.Slug)
-		fmt.Printf("\tCurrent Webhook: %s\n", pipeline.WebhookURL)
-		fmt.Printf("\tRepository https://github.com/%s\n", pipeline.Repository.String())
-
-		// lookup repositories that refer to this webhook token
-		matches, ok := repoHookMap[pipeline.WebhookToken]
-		if !ok {
-			fmt.Printf(color.YellowString("\t⚠️  No GitHub repositories with matching hooks\n"))
-		} else {
-			fmt.Printf("\tGithub Repositories with matching Webhooks:\n")
+		// don't process repositories multiple times
+		if queued[pipeline.Repository.String()] {
+			continue
 		}
+		queued[pipeline.Repository.String()] = true
 
-		// show repositories that match the pipeline webhook
-		for _, match := range matches {
-			fmt.Printf("\t\thttps://github.com/%s\n", match.githubRepository.String())
-			fmt.Printf("\t\t\tUpdate https://github.com/%s/settings/hooks/%d\n",
-				match.githubRepository.String(), *match.Hook.ID)
-		}
+		pipeline := pipeline
+		g.do(func(ctx context.Context) error {
+			log.Printf("Finding webhooks for %s", pipeline.Repository.URL())
 
-		// show unknown webhooks for the repository
-		if hooks, ok := repoHooks[pipeline.Repository.String()]; ok {
-			unknown := []*github.Hook{}
-			for _, hook := range hooks {
-				if !isHookReferencedInPipelines(hook, pipelines) {
-					unknown = append(unknown, hook)
-				}
-			}
-			if len(unknown) > 0 {
-				fmt.Printf(color.YellowString("\t⚠️  Unknown Buildkite hooks found\n"))
-				for _, hook := range unknown {
-					fmt.Printf("\t\thttps://github.com/%s\n", pipeline.Repository.String())
-					fmt.Printf("\t\t\thttps://github.com/%s/settings/hooks/%d\n",
-						pipeline.Repository.String(), *hook.ID)
-					fmt.Printf("\t\t\t\t%s\n", hook.Config["url"])
-				}
+			hooks, err := provider.ListHooks(ctx, pipeline.Repository)
+			if err != nil {
+				return fmt.Errorf("Error getting webhooks for https://buildkite.com/%s: %v",
+					pipeline.String(), err)
 			}
-		}
-
-		if *prompt {
-			fmt.Println()
 
-			if apply := prompter.YN("Rotate webhook?", true); !apply {
-				continue
-			}
-		}
+			// track the hooks for this repository
+			repoHooksMu.Lock()
+			repoHooks[pipeline.Repository.String()] = hooks
+			repoHooksMu.Unlock()
+			return nil
+		})
+	}
 
-		fmt.Println()
+	if err := g.wait(); err != nil {
+		return nil, err
+	}
 
-		if len(matches) > 0 {
-			// first off try updating it to the current value as a test
-			err = updateGithubRepositoryHook(ctx, ghClient, matches[0], pipeline.WebhookURL)
-			if err != nil {
-				log.Fatalf(color.RedString(
-					"🚨 Can't update repository webhooks, permissions perhaps? %v", err))
-			}
+	return newInventory(org, pipelines, repoHooks), nil
+}
 
-			log.Printf("Successfully tested updating github webhook")
-		}
+// newInventory maps webhook tokens to the repository hooks that refer to them. Hooks whose
+// urls can't be parsed are set aside rather than mapped.
+func newInventory(org string, pipelines []pipeline, repoHooks map[string][]*github.Hook) *inventory {
+	repoHookMap := map[string][]githubRepositoryHook{}
+	parseableHooks := map[string][]*github.Hook{}
+	var unparseable []unparseableHook
 
-		newWebhookURL, err := rotateBuildkiteWebhook(client, pipeline.ID)
-		if err != nil {
-			log.Fatalf(color.RedString(
-				"🚨 Error rotating buildkite webhooks: %v", err))
+	for _, pipeline := range pipelines {
+		if _, mapped := parseableHooks[pipeline.Repository.String()]; mapped {
+			continue
 		}
+		parseableHooks[pipeline.Repository.String()] = []*github.Hook{}
 
-		log.Printf("New buildkite webhook is %s", newWebhookURL)
-
-		// apply the new webhook to all the matching repository hooks
-		for _, match := range matches {
-			log.Printf("Updating https://github.com/%s/settings/hooks/%d",
-				match.githubRepository.String(), *match.Hook.ID)
-			err = updateGithubRepositoryHook(ctx, ghClient, match, newWebhookURL)
-			if err != nil {
-				log.Fatalf(color.RedString(
-					"🚨 Error updating github webhook: %v", err))
+		// store all the matching webhooks in our map
+		for _, hook := range repoHooks[pipeline.Repository.String()] {
+			if reason := unparseableReason(hook); reason != "" {
+				log.Printf(color.YellowString("⚠️  Leaving %s/settings/hooks/%d alone, %s",
+					pipeline.Repository.URL(), hook.GetID(), reason))
+				unparseable = append(unparseable, unparseableHook{githubRepositoryHook{pipeline.Repository, hook}, reason})
+				continue
 			}
+			parseableHooks[pipeline.Repository.String()] = append(parseableHooks[pipeline.Repository.String()], hook)
+
+			// extract just the token to allow format changes over time
+			hookToken, _ := getWebhookToken(hookURL(hook))
+			repoHookMap[hookToken] = append(repoHookMap[hookToken],
+				githubRepositoryHook{pipeline.Repository, hook})
 		}
+	}
 
-		fmt.Printf(color.GreenString("\nUpdated webhook ✅\n\n"))
+	return &inventory{
+		Org:              org,
+		Pipelines:        pipelines,
+		RepositoryHooks:  parseableHooks,
+		TokenHooks:       repoHookMap,
+		UnparseableHooks: unparseable,
 	}
 }
 
@@ -190,18 +1561,41 @@ type githubRepositoryHook struct {
 	*github.Hook
 }
 
+// unparseableHook is a hook whose url can't be parsed, and why
+type unparseableHook struct {
+	githubRepositoryHook
+	Reason string
+}
+
 type githubRepository struct {
+	Host   string
 	Org    string
 	Name   string
 	Remote string
 }
 
+// String is org/name for github.com repositories, and host/org/name for others
 func (r githubRepository) String() string {
+	if r.Host != "" && r.Host != defaultGithubHost {
+		return fmt.Sprintf("%s/%s/%s", r.Host, r.Org, r.Name)
+	}
 	return fmt.Sprintf("%s/%s", r.Org, r.Name)
 }
 
+// owner is the org, prefixed with the host for hosts other than github.com
+func (r githubRepository) owner() string {
+	if r.Host != "" && r.Host != defaultGithubHost {
+		return r.Host + "/" + r.Org
+	}
+	return r.Org
+}
+
+func (r githubRepository) URL() string {
+	return githubURL(r.String())
+}
+
 func parseGithubRepository(gitRemote string) (githubRepository, error) {
-	u, err := git.ParseGittableURL(gitRemote)
+	u, err := git.ParseGittableURL(rewriteRemote(gitRemote))
 	if err != nil {
 		return githubRepository{}, err
 	}
@@ -212,7 +1606,12 @@ func parseGithubRepository(gitRemote string) (githubRepository, error) {
 		return githubRepository{}, fmt.Errorf("Failed to parse remote %q", gitRemote)
 	}
 
-	return githubRepository{pathParts[0], pathParts[1], gitRemote}, nil
+	host := resolveHost(strings.ToLower(u.Hostname()))
+	if host == "" {
+		host = defaultGithubHost
+	}
+
+	return githubRepository{host, pathParts[0], pathParts[1], gitRemote}, nil
 }
 
 // Webhook formats over the years
@@ -220,6 +1619,23 @@ func parseGithubRepository(gitRemote string) (githubRepository, error) {
 // https://webhook.buildkite.com/github/xxxxxxxxxxxxxxxxx
 // https://webhook.buildkite.com/deliver/xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
 
+var webhookPathPattern = regexp.MustCompile(`^/(github|deliver)/[A-Za-z0-9]+$`)
+
+// validateWebhookURL checks a webhook url is in one of the current formats
+func validateWebhookURL(webhookURL string) error {
+	if webhookURL == "" {
+		return fmt.Errorf("Webhook url is empty")
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host != "webhook.buildkite.com" || !webhookPathPattern.MatchString(u.Path) {
+		return fmt.Errorf("%s doesn't look like a Buildkite webhook url", maskWebhookURL(webhookURL))
+	}
+	return nil
+}
+
 func getWebhookToken(webhookURL string) (string, error) {
 	u, err := url.Parse(webhookURL)
 	if err != nil {
@@ -229,7 +1645,7 @@ func getWebhookToken(webhookURL string) (string, error) {
 }
 
 func isHookReferencedInPipelines(hook *github.Hook, pipelines []pipeline) bool {
-	token, err := getWebhookToken(hook.Config["url"].(string))
+	token, err := getWebhookToken(hookURL(hook))
 	if err != nil {
 		return false
 	}
@@ -241,31 +1657,68 @@ func isHookReferencedInPipelines(hook *github.Hook, pipelines []pipeline) bool {
 	return false
 }
 
+// getGithubRepositoryWebhooks lists the hooks in a repository
 func getGithubRepositoryWebhooks(ctx context.Context, client *github.Client, repo githubRepository) ([]*github.Hook, error) {
 	hooks, _, err := client.Repositories.ListHooks(ctx, repo.Org, repo.Name, &github.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
+	return hooks, err
+}
 
-	var buildkiteHooks []*github.Hook
+func updateGithubRepositoryHook(ctx context.Context, client *github.Client, repoHook githubRepositoryHook, hook string, fixes hookFixes) error {
+	updated, err := checkGithubRepositoryHook(ctx, client, repoHook, hook)
+	if err != nil || updated {
+		return err
+	}
+	return editGithubRepositoryHook(ctx, client, repoHook, hook, fixes)
+}
 
-	for _, hook := range hooks {
-		webhookURL, ok := hook.Config["url"].(string)
-		if ok && strings.Contains(webhookURL, "webhook.buildbox.io") ||
-			strings.Contains(webhookURL, "webhook.buildkite.com") {
-			buildkiteHooks = append(buildkiteHooks, hook)
+// checkGithubRepositoryHook reads a hook to make sure nobody else has changed it since it was
+// listed, as github doesn't support conditional edits. It returns whether the hook already has
+// the url, like when an earlier attempt that timed out made the edit after all.
+func checkGithubRepositoryHook(ctx context.Context, client *github.Client, repoHook githubRepositoryHook, hook string) (bool, error) {
+	current, _, err := client.Repositories.GetHook(ctx, repoHook.Org, repoHook.Name, *repoHook.Hook.ID)
+	if err != nil {
+		return false, err
+	}
+	if hookConfigDigest(current) != hookConfigDigest(repoHook.Hook) {
+		if hookURL(current) == hook {
+			return true, nil
 		}
+		return false, &hookModifiedError{repoHook}
 	}
-
-	return buildkiteHooks, nil
+	return false, nil
 }
 
-func updateGithubRepositoryHook(ctx context.Context, client *github.Client, repoHook githubRepositoryHook, hook string) error {
-	// https://developer.github.com/v3/repos/hooks/#edit-a-hook
-	_, _, err := client.Repositories.EditHook(ctx, repoHook.Org, repoHook.Name, *repoHook.Hook.ID, &github.Hook{
+func editGithubRepositoryHook(ctx context.Context, client *github.Client, repoHook githubRepositoryHook, hook string, fixes hookFixes) error {
+	edit := &github.Hook{
 		Config: map[string]interface{}{
 			"url": github.String(hook),
 		},
+	}
+	fixes.apply(edit, repoHook.Hook)
+
+	// https://developer.github.com/v3/repos/hooks/#edit-a-hook
+	_, _, err := client.Repositories.EditHook(ctx, repoHook.Org, repoHook.Name, *repoHook.Hook.ID, edit)
+	return err
+}
+
+// postRotationStatus notes on the head of the default branch that a pipeline's webhook was rotated
+func postRotationStatus(ctx context.Context, client *github.Client, repo githubRepository, p pipeline) error {
+	r, _, err := client.Repositories.Get(ctx, repo.Org, repo.Name)
+	if err != nil {
+		return err
+	}
+
+	branch, _, err := client.Repositories.GetBranch(ctx, repo.Org, repo.Name, r.GetDefaultBranch())
+	if err != nil {
+		return err
+	}
+
+	// https://developer.github.com/v3/repos/statuses/#create-a-status
+	_, _, err = client.Repositories.CreateStatus(ctx, repo.Org, repo.Name, branch.GetCommit().GetSHA(), &github.RepoStatus{
+		State:       github.String("success"),
+		TargetURL:   github.String(fmt.Sprintf("https://buildkite.com/%s", p.String())),
+		Description: github.String(fmt.Sprintf("Buildkite webhook rotated on %s", time.Now().UTC().Format("2006-01-02"))),
+		Context:     github.String(fmt.Sprintf("buildkite/webhook-rotate/%s", p.Slug)),
 	})
 	return err
 }
@@ -278,103 +1731,423 @@ type pipeline struct {
 	WebhookURL   string
 	WebhookToken string
 	Repository   githubRepository
+	Cluster      string
+	ClusterUUID  string
+	Teams        []string
+	Tags         []string
+	Visibility   string
+	LastBuildAt  time.Time
+}
+
+// pipelineFilter narrows down the pipelines in scope
+type pipelineFilter struct {
+	ID              string
+	Slug            string
+	Cluster         string
+	Tags            []string
+	IncludeArchived bool
+}
+
+func (f pipelineFilter) matches(slug, clusterName, clusterUUID string, tags []string) bool {
+	if f.hasWildcard() {
+		if ok, _ := path.Match(f.Slug, slug); !ok {
+			return false
+		}
+	} else if f.Slug != "" && slug != f.Slug {
+		return false
+	}
+	if f.Cluster != "" && !strings.EqualFold(clusterName, f.Cluster) && clusterUUID != f.Cluster {
+		return false
+	}
+	// pipelines need every tag that's asked for
+	for _, want := range f.Tags {
+		found := false
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// flagSet is whether a flag was given on the command line rather than left at its default
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// isPipelineURL is whether an argument looks like a pipeline url copied from a browser
+func isPipelineURL(arg string) bool {
+	return strings.HasPrefix(arg, "https://buildkite.com/") || strings.HasPrefix(arg, "http://buildkite.com/")
+}
+
+// parsePipelineURL returns the org and slug of a pipeline url like
+// https://buildkite.com/my-org/my-pipeline, ignoring anything after them like /builds/123
+func parsePipelineURL(pipelineURL string) (string, string, error) {
+	u, err := url.Parse(pipelineURL)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to parse pipeline url %s: %v", pipelineURL, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host != "buildkite.com" || len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Expected a pipeline url like https://buildkite.com/my-org/my-pipeline, got %s", pipelineURL)
+	}
+	return parts[0], parts[1], nil
+}
+
+// hasWildcard is whether the slug is a glob pattern like deploy-*
+func (f pipelineFilter) hasWildcard() bool {
+	return strings.ContainsAny(f.Slug, "*?[")
 }
 
 func (p pipeline) String() string {
 	return fmt.Sprintf("%s/%s", p.Org, p.Slug)
 }
 
-func listGithubPipelines(client *graphql.Client, org, pipelineFilter string) ([]pipeline, error) {
-	resp, err := client.Do(`
-	query ListPipelines($org: ID!) {
-		organization(slug: $org) {
+// splitOrganizations splits a --buildkite-org of several organizations separated by commas,
+// keeping an empty one for pipelines looked up by id
+func splitOrganizations(org string) []string {
+	var orgs []string
+	for _, o := range strings.Split(org, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			orgs = append(orgs, o)
+		}
+	}
+	if len(orgs) == 0 {
+		return []string{""}
+	}
+	return orgs
+}
+
+// pipelineLister lists the github pipelines in an organization
+type pipelineLister func(org string, filter pipelineFilter) ([]pipeline, error)
+
+func graphqlPipelineLister(client *graphql.Client) pipelineLister {
+	return func(org string, filter pipelineFilter) ([]pipeline, error) {
+		if filter.ID != "" {
+			return getPipelineByID(client, org, filter.ID)
+		}
+		return listGithubPipelines(client, org, filter)
+	}
+}
+
+// pipelineFields are the fields needed for each pipeline, shared by the queries that list
+// pipelines and fetch them by id
+const pipelineFields = `
+	fragment PipelineFields on Pipeline {
+		id
+		slug
+		url
+		visibility
+		archived
+		organization {
 			slug
-			pipelines(first: 500) {
-				edges {
-					node {
-						id
+		}
+		cluster {
+			uuid
+			name
+		}
+		teams(first: 10) {
+			edges {
+				node {
+					team {
 						slug
-						url
-						repository {
-							provider {
-								__typename
-								webhookUrl
-							}
-							url
-						}
 					}
 				}
 			}
 		}
+		tags {
+			label
+		}
+		builds(first: 1) {
+			edges {
+				node {
+					createdAt
+				}
+			}
+		}
+		repository {
+			provider {
+				__typename
+				webhookUrl
+			}
+			url
+		}
 	}
-	`, map[string]interface{}{
-		`org`: org,
-	})
-	if err != nil {
-		return nil, err
-	}
+`
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s", resp.Status)
-	}
+type pipelineNode struct {
+	ID           string `json:"id"`
+	Slug         string `json:"slug"`
+	URL          string `json:"url"`
+	Visibility   string `json:"visibility"`
+	Archived     bool   `json:"archived"`
+	Organization struct {
+		Slug string `json:"slug"`
+	} `json:"organization"`
+	Cluster struct {
+		UUID string `json:"uuid"`
+		Name string `json:"name"`
+	} `json:"cluster"`
+	Teams struct {
+		Edges []struct {
+			Node struct {
+				Team struct {
+					Slug string `json:"slug"`
+				} `json:"team"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"teams"`
+	Tags []struct {
+		Label string `json:"label"`
+	} `json:"tags"`
+	Builds struct {
+		Edges []struct {
+			Node struct {
+				CreatedAt time.Time `json:"createdAt"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"builds"`
+	Repository struct {
+		Provider struct {
+			TypeName   string `json:"__typename"`
+			WebhookURL string `json:"webhookUrl"`
+		} `json:"provider"`
+		URL string `json:"url"`
+	} `json:"repository"`
+}
 
-	var parsedResp struct {
-		Data struct {
-			Organization struct {
-				Slug      string `json:"slug"`
-				Pipelines struct {
-					Edges []struct {
-						Node struct {
-							ID         string `json:"id"`
-							Slug       string `json:"slug"`
-							URL        string `json:"url"`
-							Repository struct {
-								Provider struct {
-									TypeName   string `json:"__typename"`
-									WebhookURL string `json:"webhookUrl"`
-								} `json:"provider"`
-								URL string `json:"url"`
-							} `json:"repository"`
-						} `json:"node"`
-					} `json:"edges"`
-				} `json:"pipelines"`
-			} `json:"organization"`
-		} `json:"data"`
+func (n pipelineNode) tags() []string {
+	var tags []string
+	for _, tag := range n.Tags {
+		tags = append(tags, tag.Label)
 	}
+	return tags
+}
 
-	if err = resp.DecodeInto(&parsedResp); err != nil {
-		return nil, fmt.Errorf("Failed to parse GraphQL response: %v", err)
+// isGithub is whether the pipeline builds from a github repository
+func (n pipelineNode) isGithub() bool {
+	typeName := n.Repository.Provider.TypeName
+	return typeName == githubRepositoryProvider || typeName == githubEnterpriseRepositoryProvider
+}
+
+func (n pipelineNode) pipeline() (pipeline, error) {
+	repo, err := parseGithubRepository(n.Repository.URL)
+	if err != nil {
+		return pipeline{}, err
+	}
+	webhookToken, err := getWebhookToken(n.Repository.Provider.WebhookURL)
+	if err != nil {
+		return pipeline{}, err
+	}
+	var lastBuildAt time.Time
+	for _, buildEdge := range n.Builds.Edges {
+		lastBuildAt = buildEdge.Node.CreatedAt
 	}
+	var teams []string
+	for _, teamEdge := range n.Teams.Edges {
+		teams = append(teams, teamEdge.Node.Team.Slug)
+	}
+	return pipeline{
+		ID:           n.ID,
+		URL:          n.URL,
+		Org:          n.Organization.Slug,
+		Slug:         n.Slug,
+		WebhookURL:   n.Repository.Provider.WebhookURL,
+		WebhookToken: webhookToken,
+		Repository:   repo,
+		Cluster:      n.Cluster.Name,
+		ClusterUUID:  n.Cluster.UUID,
+		Teams:        teams,
+		Tags:         n.tags(),
+		Visibility:   strings.ToLower(n.Visibility),
+		LastBuildAt:  lastBuildAt,
+	}, nil
+}
 
+func listGithubPipelines(client *graphql.Client, org string, filter pipelineFilter) ([]pipeline, error) {
 	var pipelines []pipeline
-	for _, pipelineEdge := range parsedResp.Data.Organization.Pipelines.Edges {
-		if pipelineFilter != "" && pipelineEdge.Node.Slug != pipelineFilter {
+	var counts listingCounts
+	var pages int
+	var cursor interface{}
+	var rateLimit graphqlRateLimit
+	pageSize := maxPipelinePageSize
+
+	for attempt := 1; ; attempt++ {
+		// wait for the limit to reset rather than being rejected part way through listing
+		rateLimit.wait()
+
+		resp, err := client.Do(`
+		query ListPipelines($org: ID!, $cursor: String, $first: Int!) {
+			organization(slug: $org) {
+				pipelines(first: $first, after: $cursor) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+					edges {
+						node {
+							...PipelineFields
+						}
+					}
+				}
+			}
+		}
+		`+pipelineFields, map[string]interface{}{
+			`org`:    org,
+			`cursor`: cursor,
+			`first`:  pageSize,
+		})
+		if resp != nil {
+			rateLimit.update(resp)
+		}
+
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests && attempt <= maxGraphqlAttempts {
+			wait := rateLimit.resetIn(attempt)
+			log.Printf(color.YellowString("⚠️  Buildkite GraphQL rate limit exceeded, waiting %v", wait))
+			time.Sleep(wait)
 			continue
 		}
-		if pipelineEdge.Node.Repository.Provider.TypeName != githubRepositoryProvider {
+
+		// smaller pages are less complex, so very large organizations can still be listed
+		if isComplexityError(err) && pageSize > minPipelinePageSize && attempt <= maxGraphqlAttempts {
+			if pageSize /= 2; pageSize < minPipelinePageSize {
+				pageSize = minPipelinePageSize
+			}
+			log.Printf(color.YellowString("⚠️  %v, listing %d pipelines at a time instead", err, pageSize))
 			continue
 		}
-		repo, err := parseGithubRepository(pipelineEdge.Node.Repository.URL)
 		if err != nil {
 			return nil, err
 		}
-		webhookToken, err := getWebhookToken(pipelineEdge.Node.Repository.Provider.WebhookURL)
-		if err != nil {
-			return nil, err
+
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("%s", resp.Status)
 		}
-		pipelines = append(pipelines, pipeline{
-			ID:           pipelineEdge.Node.ID,
-			URL:          pipelineEdge.Node.URL,
-			Org:          org,
-			Slug:         pipelineEdge.Node.Slug,
-			WebhookURL:   pipelineEdge.Node.Repository.Provider.WebhookURL,
-			WebhookToken: webhookToken,
-			Repository:   repo,
-		})
+
+		pages++
+		attempt = 0
+
+		var parsedResp struct {
+			Data struct {
+				Organization *struct {
+					Pipelines struct {
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						Edges []struct {
+							Node pipelineNode `json:"node"`
+						} `json:"edges"`
+					} `json:"pipelines"`
+				} `json:"organization"`
+			} `json:"data"`
+		}
+
+		if err = resp.DecodeInto(&parsedResp); err != nil {
+			return nil, fmt.Errorf("Failed to parse GraphQL response: %v", err)
+		}
+
+		// organizations the token can't access are null rather than an error
+		if parsedResp.Data.Organization == nil {
+			return nil, errOrganizationNotFound(org)
+		}
+
+		for _, pipelineEdge := range parsedResp.Data.Organization.Pipelines.Edges {
+			node := pipelineEdge.Node
+			counts.total++
+			if !filter.matches(node.Slug, node.Cluster.Name, node.Cluster.UUID, node.tags()) {
+				counts.filtered++
+				continue
+			}
+			// archived pipelines don't build, so rotating them is wasted effort
+			if node.Archived && !filter.IncludeArchived {
+				counts.archived++
+				continue
+			}
+			if !node.isGithub() {
+				counts.nonGithub++
+				continue
+			}
+			p, err := node.pipeline()
+			if err != nil {
+				return nil, err
+			}
+			pipelines = append(pipelines, p)
+		}
+
+		pageInfo := parsedResp.Data.Organization.Pipelines.PageInfo
+		if !pageInfo.HasNextPage {
+			break
+		}
+		cursor = pageInfo.EndCursor
+	}
+
+	log.Printf("Listed pipelines in %d GraphQL requests%s", pages, rateLimit)
+	if counts.archived > 0 && len(pipelines) > 0 {
+		log.Printf("Skipping %d archived pipelines, use --include-archived to include them", counts.archived)
 	}
+	counts.explain(org, len(pipelines))
 	return pipelines, nil
 }
 
+// getPipelineByID fetches a single pipeline by its graphql id, without listing the
+// organization's pipelines
+func getPipelineByID(client *graphql.Client, org, id string) ([]pipeline, error) {
+	resp, err := client.Do(`
+		query GetPipeline($id: ID!) {
+			node(id: $id) {
+				__typename
+				...PipelineFields
+			}
+		}
+		`+pipelineFields, map[string]interface{}{
+		`id`: id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedResp struct {
+		Data struct {
+			Node *struct {
+				TypeName string `json:"__typename"`
+				pipelineNode
+			} `json:"node"`
+		} `json:"data"`
+	}
+
+	if err = resp.DecodeInto(&parsedResp); err != nil {
+		return nil, fmt.Errorf("Failed to parse GraphQL response: %v", err)
+	}
+
+	node := parsedResp.Data.Node
+	if node == nil || node.TypeName != "Pipeline" {
+		return nil, fmt.Errorf("No pipeline with id %s", id)
+	}
+	if org != "" && node.Organization.Slug != org {
+		return nil, fmt.Errorf("Pipeline %s is in %s, not %s", id, node.Organization.Slug, org)
+	}
+	if !node.isGithub() {
+		return nil, fmt.Errorf("Pipeline %s/%s doesn't build from a GitHub repository", node.Organization.Slug, node.Slug)
+	}
+
+	p, err := node.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	return []pipeline{p}, nil
+}
+
 func rotateBuildkiteWebhook(client *graphql.Client, pipelineID string) (string, error) {
 	resp, err := client.Do(`
 		mutation($input: PipelineRotateWebhookURLInput!) {
@@ -406,5 +2179,21 @@ func rotateBuildkiteWebhook(client *graphql.Client, pipelineID string) (string,
 		return "", fmt.Errorf("Failed to parse GraphQL response: %v", err)
 	}
 
-	return parsedResp.Data.PipelineRotateWebhookURL.Pipeline.WebhookURL, nil
+	// the webhook has been rotated either way, but a bad url mustn't be written to hooks
+	webhookURL := parsedResp.Data.PipelineRotateWebhookURL.Pipeline.WebhookURL
+	if err = validateWebhookURL(webhookURL); err != nil {
+		return webhookURL, &invalidWebhookURLError{err}
+	}
+
+	return webhookURL, nil
+}
+
+// invalidWebhookURLError is returned when a pipeline was rotated but buildkite returned a
+// webhook url that doesn't look right, so the old webhook is gone but hooks can't be updated
+type invalidWebhookURLError struct {
+	err error
+}
+
+func (e *invalidWebhookURLError) Error() string {
+	return fmt.Sprintf("Buildkite returned an unexpected webhook url, check the pipeline's settings: %v", e.err)
 }
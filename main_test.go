@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+	for _, tc := range []struct {
+		url   string
+		valid bool
+	}{
+		{"https://webhook.buildkite.com/deliver/5f0c6b2e8a1d4c3b9e7f2a6d1c8b4e3f5a9d2c7b6e1f4a8d3c", true},
+		{"https://webhook.buildkite.com/github/0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d", true},
+		{"", false},
+		{"http://webhook.buildkite.com/deliver/5f0c6b2e8a1d", false},
+		{"https://webhook.buildbox.io/github/0a1b2c3d4e5f", false},
+		{"https://webhook.buildkite.com.example.com/deliver/5f0c6b2e8a1d", false},
+		{"https://webhook.buildkite.com:8443/deliver/5f0c6b2e8a1d", false},
+		{"https://webhook.buildkite.com/deliver/", false},
+		{"https://webhook.buildkite.com/deliver/5f0c6b2e-8a1d", false},
+		{"https://webhook.buildkite.com/deliver/5f0c6b2e8a1d/extra", false},
+		{"https://webhook.buildkite.com/other/5f0c6b2e8a1d", false},
+		{"webhook.buildkite.com/deliver/5f0c6b2e8a1d", false},
+		{"https://webhook.buildkite.com/deliver/%zz", false},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			err := validateWebhookURL(tc.url)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected %q to be valid, got %v", tc.url, err)
+			} else if !tc.valid && err == nil {
+				t.Fatalf("Expected %q to be invalid", tc.url)
+			}
+		})
+	}
+}
+
+func TestSplitOrganizations(t *testing.T) {
+	for _, tc := range []struct {
+		org  string
+		want []string
+	}{
+		{"acme", []string{"acme"}},
+		{"acme,acme-oss", []string{"acme", "acme-oss"}},
+		{" acme , acme-oss,", []string{"acme", "acme-oss"}},
+		{"", []string{""}},
+	} {
+		if got := splitOrganizations(tc.org); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("Expected %q to be split into %q, got %q", tc.org, tc.want, got)
+		}
+	}
+}
+
+// listingProvider is a repository provider that counts how often each repository's hooks
+// are listed
+type listingProvider struct {
+	hooks  map[string][]*github.Hook
+	mu     sync.Mutex
+	listed map[string]int
+}
+
+func (p *listingProvider) ListHooks(ctx context.Context, repo githubRepository) ([]*github.Hook, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listed[repo.String()]++
+	return p.hooks[repo.String()], nil
+}
+
+func (p *listingProvider) MatchHook(hook *github.Hook) bool {
+	return true
+}
+
+func (p *listingProvider) UpdateHook(ctx context.Context, match githubRepositoryHook, webhookURL string, fixes hookFixes) error {
+	return errors.New("Hooks can't be updated")
+}
+
+func (p *listingProvider) CreateHook(ctx context.Context, repo githubRepository, hook *github.Hook) (*github.Hook, error) {
+	return nil, errors.New("Hooks can't be created")
+}
+
+func TestDiscoverWebhooksAcrossOrganizations(t *testing.T) {
+	shared := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "shared"}
+	web := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}
+	orgPipelines := map[string][]pipeline{
+		"acme": {
+			{ID: "web-id", Org: "acme", Slug: "web", Repository: web, WebhookToken: "0a1b2c3d"},
+			{ID: "shared-id", Org: "acme", Slug: "shared", Repository: shared, WebhookToken: "4e5f6a7b"},
+		},
+		"acme-oss": {
+			{ID: "oss-shared-id", Org: "acme-oss", Slug: "shared", Repository: shared, WebhookToken: "8c9d0e1f"},
+		},
+	}
+	listPipelines := func(org string, filter pipelineFilter) ([]pipeline, error) {
+		return orgPipelines[org], nil
+	}
+	hook := func(id int64, token string) *github.Hook {
+		return &github.Hook{ID: github.Int64(id), Config: map[string]interface{}{"url": "https://webhook.buildkite.com/deliver/" + token}}
+	}
+	provider := &listingProvider{
+		hooks: map[string][]*github.Hook{
+			"acme/web":    {hook(1001, "0a1b2c3d")},
+			"acme/shared": {hook(2001, "4e5f6a7b"), hook(2002, "8c9d0e1f")},
+		},
+		listed: map[string]int{},
+	}
+
+	inv, err := discoverWebhooks(context.Background(), listPipelines, provider, "acme,acme-oss", pipelineFilter{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]int{"acme/web": 1, "acme/shared": 1}; !reflect.DeepEqual(provider.listed, want) {
+		t.Fatalf("Expected each repository to be listed once, got %v", provider.listed)
+	}
+	if len(inv.Pipelines) != 3 {
+		t.Fatalf("Expected the pipelines of both organizations, got %d", len(inv.Pipelines))
+	}
+	for token, id := range map[string]int64{"0a1b2c3d": 1001, "4e5f6a7b": 2001, "8c9d0e1f": 2002} {
+		if matches := inv.TokenHooks[token]; len(matches) != 1 || matches[0].GetID() != id {
+			t.Fatalf("Expected %s to match hook %d, got %v", token, id, matches)
+		}
+	}
+	if unknown := inv.unknownHooks(shared); len(unknown) > 0 {
+		t.Fatalf("Expected the shared repository's hooks to be known to one organization or the other, got %d unknown", len(unknown))
+	}
+}
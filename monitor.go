@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// driftMonitor audits the organization again on an interval between rotations, and
+// alerts on hooks that drift to another webhook and unknown buildkite hooks that appear
+type driftMonitor struct {
+	discover func() (*inventory, error)
+	interval time.Duration
+
+	// alerts get each change as well as it being logged
+	alerts *alertThrottle
+}
+
+// run audits forever, reporting each change from the audit before it
+func (m *driftMonitor) run(baseline []inventoryRecord) {
+	log.Printf("Watching for drift every %v", m.interval)
+
+	last := baseline
+	for {
+		time.Sleep(m.interval)
+
+		inv, err := m.discover()
+		if err != nil {
+			m.notify(fmt.Sprintf("Failed to audit webhooks: %v", err))
+			m.alerts.round()
+			continue
+		}
+		records := inv.records()
+
+		diff := diffInventories(last, records)
+		for _, r := range diff.Added {
+			if r.Unparseable != "" {
+				m.notify(fmt.Sprintf("Hook with an unparseable url appeared at %s/settings/hooks/%d, %s", githubURL(r.Repository), r.HookID, r.Unparseable))
+			} else if r.Pipeline == "" {
+				m.notify(fmt.Sprintf("Unknown Buildkite hook appeared at %s/settings/hooks/%d", githubURL(r.Repository), r.HookID))
+			} else {
+				m.notify(fmt.Sprintf("Added %s", r))
+			}
+		}
+		for _, r := range diff.Removed {
+			m.notify(fmt.Sprintf("Removed %s", r))
+		}
+		for _, c := range diff.Changed {
+			m.notify(fmt.Sprintf("%s has drifted, %s", c.After, c.describe()))
+		}
+		if diff.empty() {
+			log.Printf("No drift found in %d hooks", len(records))
+		}
+
+		last = records
+		m.alerts.round()
+	}
+}
+
+func (m *driftMonitor) notify(message string) {
+	log.Printf(color.YellowString("⚠️  %s", message))
+	m.alerts.send(message)
+}
+
+// describe says what changed about a record, with webhook tokens masked
+func (c inventoryChange) describe() string {
+	var changes []string
+	if c.Before.PipelineID != c.After.PipelineID {
+		changes = append(changes, fmt.Sprintf("pipeline id %s -> %s", c.Before.PipelineID, c.After.PipelineID))
+	}
+	if c.Before.WebhookToken != c.After.WebhookToken {
+		changes = append(changes, fmt.Sprintf("webhook token %s -> %s", c.Before.WebhookToken, c.After.WebhookToken))
+	}
+	if c.Before.HookURL != c.After.HookURL {
+		changes = append(changes, fmt.Sprintf("hook url %s -> %s", c.Before.HookURL, c.After.HookURL))
+	}
+	return strings.Join(changes, ", ")
+}
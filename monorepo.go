@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// repositoryGroupResult is the combined outcome of the pipelines that share a repository,
+// which are rotated as a unit when grouping by repository
+type repositoryGroupResult struct {
+	Repository string   `json:"repository"`
+	Pipelines  []string `json:"pipelines"`
+	Outcome    string   `json:"outcome"`
+}
+
+// sharedBy returns the pipelines that build from a repository
+func (inv *inventory) sharedBy(repo githubRepository) []pipeline {
+	var shared []pipeline
+	for _, p := range inv.Pipelines {
+		if p.Repository.String() == repo.String() {
+			shared = append(shared, p)
+		}
+	}
+	return shared
+}
+
+// groupMatches returns the hooks that refer to any of a group's webhooks
+func (inv *inventory) groupMatches(group []pipeline) []githubRepositoryHook {
+	var matches []githubRepositoryHook
+	for _, p := range group {
+		matches = append(matches, inv.TokenHooks[p.WebhookToken]...)
+	}
+	return matches
+}
+
+// repositoryGroups summarizes the results of pipelines that share a repository
+func (inv *inventory) repositoryGroups(report *runReport) []repositoryGroupResult {
+	results := map[string]pipelineResult{}
+	for _, result := range report.Pipelines {
+		results[result.PipelineID] = result
+	}
+
+	var groups []repositoryGroupResult
+	grouped := map[string]bool{}
+	for _, p := range inv.Pipelines {
+		repo := p.Repository.String()
+		if grouped[repo] {
+			continue
+		}
+		grouped[repo] = true
+
+		shared := inv.sharedBy(p.Repository)
+		if len(shared) < 2 {
+			continue
+		}
+
+		group := repositoryGroupResult{Repository: repo}
+		outcomes := map[string]int{}
+		for _, member := range shared {
+			if result, ok := results[member.ID]; ok {
+				group.Pipelines = append(group.Pipelines, result.Pipeline)
+				outcomes[result.Outcome]++
+			}
+		}
+		if len(group.Pipelines) == 0 {
+			continue
+		}
+
+		switch len(group.Pipelines) {
+		case outcomes[outcomeRotated]:
+			group.Outcome = outcomeRotated
+		case outcomes[outcomeSkipped]:
+			group.Outcome = outcomeSkipped
+		case outcomes[outcomeFailed] + outcomes[outcomeSkipped]:
+			group.Outcome = outcomeFailed
+		default:
+			group.Outcome = outcomePartial
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Repository < groups[j].Repository
+	})
+	return groups
+}
+
+// testHooks updates a hook in each repository to its current url, so that a group of
+// pipelines is only rotated if all of their hooks can be updated afterwards
+func (r *rotator) testHooks(ctx context.Context, matches []githubRepositoryHook) error {
+	for _, match := range matches {
+		repo := match.githubRepository.String()
+		if r.testedRepos[repo] {
+			continue
+		}
+
+		if err := r.provider.UpdateHook(ctx, match, hookURL(match.Hook), hookFixes{}); err != nil {
+			return fmt.Errorf("Can't update webhooks in %s, permissions perhaps? %v", match.githubRepository.URL(), err)
+		}
+
+		r.testedRepos[repo] = true
+	}
+
+	if len(matches) > 0 {
+		log.Printf("Successfully tested updating github webhooks for the group")
+	}
+	return nil
+}
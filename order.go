@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// sortPipelines orders pipelines so operators can prioritize, the most recently built and
+// those with the least recently updated hooks come first
+func sortPipelines(pipelines []pipeline, sortBy string, inv *inventory) ([]pipeline, error) {
+	sorted := append([]pipeline{}, pipelines...)
+
+	var less func(a, b pipeline) bool
+	switch sortBy {
+	case "":
+		return sorted, nil
+	case "slug":
+		less = func(a, b pipeline) bool {
+			return a.String() < b.String()
+		}
+	case "repo":
+		less = func(a, b pipeline) bool {
+			return a.Repository.String() < b.Repository.String()
+		}
+	case "last-build":
+		less = func(a, b pipeline) bool {
+			return a.LastBuildAt.After(b.LastBuildAt)
+		}
+	case "webhook-age":
+		less = func(a, b pipeline) bool {
+			return webhookUpdatedAt(inv, a).Before(webhookUpdatedAt(inv, b))
+		}
+	default:
+		return nil, fmt.Errorf("Unknown sort %q, expected slug, repo, last-build or webhook-age", sortBy)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	return sorted, nil
+}
+
+// webhookUpdatedAt is when the least recently updated hook for a pipeline was last changed,
+// pipelines without hooks are treated as the most recently updated
+func webhookUpdatedAt(inv *inventory, p pipeline) time.Time {
+	var updatedAt time.Time
+	for _, match := range inv.TokenHooks[p.WebhookToken] {
+		if match.Hook.UpdatedAt != nil && (updatedAt.IsZero() || match.Hook.UpdatedAt.Before(updatedAt)) {
+			updatedAt = *match.Hook.UpdatedAt
+		}
+	}
+	if updatedAt.IsZero() {
+		return time.Now()
+	}
+	return updatedAt
+}
+
+// groupPipelines orders pipelines so that those in the same group are processed together
+func groupPipelines(pipelines []pipeline, groupBy string) ([]pipeline, error) {
+	grouped := append([]pipeline{}, pipelines...)
+
+	switch groupBy {
+	case "", "pipeline":
+	case "repo":
+		sort.SliceStable(grouped, func(i, j int) bool {
+			return grouped[i].Repository.String() < grouped[j].Repository.String()
+		})
+	default:
+		return nil, fmt.Errorf("Unknown group %q, expected pipeline or repo", groupBy)
+	}
+
+	return grouped, nil
+}
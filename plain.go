@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// stdout is where output for people goes, which is replaced by a plainWriter with --plain
+var stdout io.Writer = os.Stdout
+
+// plainReplacer swaps emoji and other symbols for ascii that says the same thing, for
+// terminals, screen readers and ticketing systems that render them poorly
+var plainReplacer = strings.NewReplacer(
+	"🚨 ", "ERROR: ",
+	"🚨", "ERROR:",
+	"⚠️  ", "WARNING: ",
+	"⚠️", "WARNING:",
+	" ✅", " [OK]",
+	"✅", "[OK]",
+	"→", "->",
+	"…", "...",
+)
+
+// plainWriter writes output with the emoji replaced
+type plainWriter struct {
+	w io.Writer
+}
+
+func (p plainWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(p.w, plainReplacer.Replace(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
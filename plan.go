@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+	"golang.org/x/crypto/ed25519"
+)
+
+// rotationPlan is a set of pipelines to rotate that is generated by one operator and
+// approved by another before it can be applied
+type rotationPlan struct {
+	Organization string            `json:"organization"`
+	CreatedAt    time.Time         `json:"created_at"`
+	PlannedBy    string            `json:"planned_by"`
+	Pipelines    []plannedPipeline `json:"pipelines"`
+	Digest       string            `json:"digest"`
+	Approvals    []planApproval    `json:"approvals,omitempty"`
+}
+
+type plannedPipeline struct {
+	ID       string        `json:"id"`
+	Pipeline string        `json:"pipeline"`
+	Teams    []string      `json:"teams,omitempty"`
+	Hooks    []plannedHook `json:"hooks"`
+}
+
+type plannedHook struct {
+	Repository string `json:"repository"`
+	ID         int64  `json:"id"`
+
+	// Config is a digest of the hook's config when it was planned, to detect changes
+	// made to it before the plan is applied
+	Config string `json:"config,omitempty"`
+}
+
+// planApproval is signed by the approver's ed25519 key, so it can't be made up by anyone
+// who can write to the plan file
+type planApproval struct {
+	ApprovedBy string    `json:"approved_by"`
+	ApprovedAt time.Time `json:"approved_at"`
+	Digest     string    `json:"digest"`
+	Signature  string    `json:"signature,omitempty"`
+}
+
+// signed is what an approval's signature covers
+func (a planApproval) signed() []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s", a.Digest, strings.ToLower(a.ApprovedBy), a.ApprovedAt.Format(time.RFC3339Nano)))
+}
+
+// newRotationPlan plans to rotate the pipelines in an inventory that the policy allows
+func newRotationPlan(inv *inventory, plannedBy string, policy *rotationPolicy) *rotationPlan {
+	plan := &rotationPlan{
+		Organization: inv.Org,
+		CreatedAt:    time.Now().UTC(),
+		PlannedBy:    plannedBy,
+	}
+	for _, pipeline := range inv.Pipelines {
+		if err := policy.check(pipeline, inv.TokenHooks[pipeline.WebhookToken]); err != nil {
+			log.Printf(color.YellowString("⚠️  Leaving https://buildkite.com/%s out of the plan: %v", pipeline.String(), err))
+			continue
+		}
+		plan.Pipelines = append(plan.Pipelines, plannedPipeline{
+			ID:       pipeline.ID,
+			Pipeline: pipeline.String(),
+			Teams:    pipeline.Teams,
+			Hooks:    plannedHooks(inv.TokenHooks[pipeline.WebhookToken]),
+		})
+	}
+	plan.Digest = plan.digest()
+	return plan
+}
+
+func plannedHooks(matches []githubRepositoryHook) []plannedHook {
+	hooks := []plannedHook{}
+	for _, match := range matches {
+		hooks = append(hooks, plannedHook{match.githubRepository.String(), *match.Hook.ID, hookConfigDigest(match.Hook)})
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Repository != hooks[j].Repository {
+			return hooks[i].Repository < hooks[j].Repository
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+// digest is a hash of everything in the plan except the approvals, so that any
+// change to the plan after it was approved can be detected
+func (p *rotationPlan) digest() string {
+	b, _ := json.Marshal(struct {
+		Organization string
+		CreatedAt    time.Time
+		PlannedBy    string
+		Pipelines    []plannedPipeline
+	}{p.Organization, p.CreatedAt, p.PlannedBy, p.Pipelines})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// verify checks the plan hasn't been modified since it was generated
+func (p *rotationPlan) verify() error {
+	if p.digest() != p.Digest {
+		return fmt.Errorf("Plan has been modified since it was generated by %s", p.PlannedBy)
+	}
+	return nil
+}
+
+// verifyApproval checks the plan has been approved by someone other than the planner, with
+// a signature from the key the approver is known by
+func (p *rotationPlan) verifyApproval(approvers approverKeys) error {
+	if err := p.verify(); err != nil {
+		return err
+	}
+	for _, approval := range p.Approvals {
+		if approval.Digest != p.Digest || strings.EqualFold(approval.ApprovedBy, p.PlannedBy) {
+			continue
+		}
+		key, ok := approvers[strings.ToLower(approval.ApprovedBy)]
+		if !ok {
+			log.Printf(color.YellowString("⚠️  Ignoring approval by %s, who isn't one of the approvers", approval.ApprovedBy))
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(approval.Signature)
+		if err != nil || !ed25519.Verify(key, approval.signed(), sig) {
+			log.Printf(color.YellowString("⚠️  Ignoring approval by %s, its signature isn't from their key", approval.ApprovedBy))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("Plan generated by %s hasn't been approved by a second operator", p.PlannedBy)
+}
+
+func (p *rotationPlan) approve(approvedBy string, key ed25519.PrivateKey) error {
+	if err := p.verify(); err != nil {
+		return err
+	}
+	if strings.EqualFold(approvedBy, p.PlannedBy) {
+		return fmt.Errorf("Plans must be approved by someone other than the planner (%s)", p.PlannedBy)
+	}
+	approval := planApproval{
+		ApprovedBy: approvedBy,
+		ApprovedAt: time.Now().UTC(),
+		Digest:     p.Digest,
+	}
+	approval.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, approval.signed()))
+	p.Approvals = append(p.Approvals, approval)
+	return nil
+}
+
+// approverKeys are the ed25519 public keys of the operators who can approve plans, by github login
+type approverKeys map[string]ed25519.PublicKey
+
+// readApproverKeys reads a file with a github login and a base64 ed25519 public key on each line
+func readApproverKeys(path string) (approverKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	approvers := approverKeys{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Expected a login and a public key on line %d of %s", i+1, path)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Expected a base64 %d byte ed25519 public key for %s in %s", ed25519.PublicKeySize, fields[0], path)
+		}
+		approvers[strings.ToLower(fields[0])] = ed25519.PublicKey(key)
+	}
+	return approvers, nil
+}
+
+func (p *rotationPlan) pipeline(id string) (plannedPipeline, bool) {
+	for _, planned := range p.Pipelines {
+		if planned.ID == id {
+			return planned, true
+		}
+	}
+	return plannedPipeline{}, false
+}
+
+// missing returns the planned pipelines that no longer exist
+func (p *rotationPlan) missing(pipelines []pipeline) []string {
+	var missing []string
+	for _, planned := range p.Pipelines {
+		found := false
+		for _, pipeline := range pipelines {
+			if pipeline.ID == planned.ID {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, planned.Pipeline)
+		}
+	}
+	return missing
+}
+
+// checkHooks makes sure the hooks that would be updated are the ones that were approved
+func (pp plannedPipeline) checkHooks(matches []githubRepositoryHook) error {
+	live := plannedHooks(matches)
+	if len(live) != len(pp.Hooks) {
+		return fmt.Errorf("Hooks for %s have changed since the plan was generated", pp.Pipeline)
+	}
+	for i := range live {
+		if live[i].Repository != pp.Hooks[i].Repository || live[i].ID != pp.Hooks[i].ID {
+			return fmt.Errorf("Hooks for %s have changed since the plan was generated", pp.Pipeline)
+		}
+	}
+	return nil
+}
+
+// modifiedHooks describes the hooks whose config has changed since the plan was generated,
+// like by someone editing them at the same time. Plans from before configs were recorded
+// can't tell, so they never have modified hooks.
+func (pp plannedPipeline) modifiedHooks(matches []githubRepositoryHook) []string {
+	var modified []string
+	for _, match := range matches {
+		for _, hook := range pp.Hooks {
+			if hook.Repository == match.githubRepository.String() && hook.ID == *match.Hook.ID &&
+				hook.Config != "" && hook.Config != hookConfigDigest(match.Hook) {
+				modified = append(modified, fmt.Sprintf("%s/settings/hooks/%d", match.githubRepository.URL(), hook.ID))
+			}
+		}
+	}
+	return modified
+}
+
+// refetchHooks lists the hooks again right before they're edited, so changes made since they
+// were discovered are noticed
+func refetchHooks(ctx context.Context, provider repositoryProvider, matches []githubRepositoryHook) ([]githubRepositoryHook, error) {
+	listed := map[string][]*github.Hook{}
+	var current []githubRepositoryHook
+	for _, match := range matches {
+		hooks, ok := listed[match.githubRepository.String()]
+		if !ok {
+			var err error
+			if hooks, err = provider.ListHooks(ctx, match.githubRepository); err != nil {
+				return nil, err
+			}
+			listed[match.githubRepository.String()] = hooks
+		}
+
+		found := false
+		for _, hook := range hooks {
+			if hook.GetID() == *match.Hook.ID {
+				current = append(current, githubRepositoryHook{match.githubRepository, hook})
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s/settings/hooks/%d has been deleted", match.githubRepository.URL(), *match.Hook.ID)
+		}
+	}
+	return current, nil
+}
+
+// hookConfigDigest is a hash of the parts of a hook's config that rotating could overwrite
+func hookConfigDigest(hook *github.Hook) string {
+	events := append([]string{}, hook.Events...)
+	sort.Strings(events)
+	b, _ := json.Marshal(struct {
+		URL         string
+		ContentType string
+		InsecureSSL bool
+		Active      bool
+		Events      []string
+	}{hookURL(hook), hookContentType(hook), hookInsecureSSL(hook), hook.GetActive(), events})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func readRotationPlan(path string) (*rotationPlan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan rotationPlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("Failed to parse plan %s: %v", path, err)
+	}
+	return &plan, nil
+}
+
+func writeRotationPlan(path string, plan *rotationPlan) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+func printRotationPlan(w io.Writer, plan *rotationPlan) {
+	fmt.Fprintf(w, "Plan for %s generated by %s at %s\n", plan.Organization, plan.PlannedBy,
+		plan.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Digest: %s\n\n", plan.Digest)
+	for _, planned := range plan.Pipelines {
+		fmt.Fprintf(w, "Pipeline: https://buildkite.com/%s\n", planned.Pipeline)
+		if len(planned.Teams) > 0 {
+			fmt.Fprintf(w, "\tTeams: %s\n", strings.Join(planned.Teams, ", "))
+		}
+		for _, hook := range planned.Hooks {
+			fmt.Fprintf(w, "\tUpdate %s/settings/hooks/%d\n", githubURL(hook.Repository), hook.ID)
+		}
+	}
+	for _, approval := range plan.Approvals {
+		fmt.Fprintf(w, "\nApproved by %s at %s\n", approval.ApprovedBy, approval.ApprovedAt.Format(time.RFC3339))
+	}
+}
+
+// githubLogin returns the identity of the user a github token belongs to
+func githubLogin(ctx context.Context, client *github.Client) (string, error) {
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func testPlan() *rotationPlan {
+	plan := &rotationPlan{
+		Organization: "acme",
+		CreatedAt:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		PlannedBy:    "alice",
+		Pipelines: []plannedPipeline{{
+			ID:       "UGlwZWxpbmUtLS13ZWI=",
+			Pipeline: "acme/web",
+			Hooks:    []plannedHook{{Repository: "github.com/acme/web", ID: 1001, Config: "0123456789abcdef"}},
+		}},
+	}
+	plan.Digest = plan.digest()
+	return plan
+}
+
+func testKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func TestPlanVerify(t *testing.T) {
+	if err := testPlan().verify(); err != nil {
+		t.Fatalf("Expected an unmodified plan to verify, got %v", err)
+	}
+
+	for name, modify := range map[string]func(*rotationPlan){
+		"organization": func(p *rotationPlan) { p.Organization = "other" },
+		"planner":      func(p *rotationPlan) { p.PlannedBy = "mallory" },
+		"pipeline":     func(p *rotationPlan) { p.Pipelines[0].ID = "other" },
+		"hook":         func(p *rotationPlan) { p.Pipelines[0].Hooks[0].ID = 1002 },
+		"hook config":  func(p *rotationPlan) { p.Pipelines[0].Hooks[0].Config = "" },
+		"extra pipeline": func(p *rotationPlan) {
+			p.Pipelines = append(p.Pipelines, plannedPipeline{ID: "other", Pipeline: "acme/other"})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			plan := testPlan()
+			modify(plan)
+			if err := plan.verify(); err == nil {
+				t.Fatal("Expected a modified plan to fail to verify")
+			}
+		})
+	}
+}
+
+func TestPlanApprove(t *testing.T) {
+	_, key := testKey(t)
+
+	if err := testPlan().approve("ALICE", key); err == nil {
+		t.Fatal("Expected the planner to be unable to approve their own plan")
+	}
+
+	plan := testPlan()
+	plan.Pipelines[0].Pipeline = "acme/other"
+	if err := plan.approve("bob", key); err == nil {
+		t.Fatal("Expected a modified plan to be unable to be approved")
+	}
+}
+
+func TestPlanVerifyApproval(t *testing.T) {
+	bobPublic, bobKey := testKey(t)
+	alicePublic, aliceKey := testKey(t)
+	_, otherKey := testKey(t)
+	approvers := approverKeys{"alice": alicePublic, "bob": bobPublic}
+
+	for _, tc := range []struct {
+		name    string
+		approve func(*rotationPlan)
+		valid   bool
+	}{
+		{
+			name:    "not approved",
+			approve: func(p *rotationPlan) {},
+		},
+		{
+			name:    "approved by an approver",
+			approve: func(p *rotationPlan) { p.approve("bob", bobKey) },
+			valid:   true,
+		},
+		{
+			name:    "approver login in another case",
+			approve: func(p *rotationPlan) { p.approve("Bob", bobKey) },
+			valid:   true,
+		},
+		{
+			name:    "signed with someone else's key",
+			approve: func(p *rotationPlan) { p.approve("bob", otherKey) },
+		},
+		{
+			name:    "signed by the planner as someone else",
+			approve: func(p *rotationPlan) { p.approve("bob", aliceKey) },
+		},
+		{
+			name:    "approved by someone who isn't an approver",
+			approve: func(p *rotationPlan) { p.approve("carol", otherKey) },
+		},
+		{
+			name: "unsigned approval",
+			approve: func(p *rotationPlan) {
+				p.Approvals = append(p.Approvals, planApproval{ApprovedBy: "bob", ApprovedAt: time.Now(), Digest: p.Digest})
+			},
+		},
+		{
+			name: "approver changed after signing",
+			approve: func(p *rotationPlan) {
+				p.approve("carol", otherKey)
+				p.Approvals[0].ApprovedBy = "bob"
+			},
+		},
+		{
+			name: "approval of an earlier digest",
+			approve: func(p *rotationPlan) {
+				p.approve("bob", bobKey)
+				p.Approvals[0].Digest = strings.Repeat("0", 64)
+			},
+		},
+		{
+			name: "self approval added to the file",
+			approve: func(p *rotationPlan) {
+				approval := planApproval{ApprovedBy: "alice", ApprovedAt: time.Now(), Digest: p.Digest}
+				approval.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(aliceKey, approval.signed()))
+				p.Approvals = append(p.Approvals, approval)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := testPlan()
+			tc.approve(plan)
+			err := plan.verifyApproval(approvers)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the approval to be valid, got %v", err)
+			} else if !tc.valid && err == nil {
+				t.Fatal("Expected the approval to be rejected")
+			}
+		})
+	}
+
+	t.Run("plan modified after approval", func(t *testing.T) {
+		plan := testPlan()
+		plan.approve("bob", bobKey)
+		plan.Pipelines[0].Hooks = append(plan.Pipelines[0].Hooks, plannedHook{Repository: "github.com/acme/api", ID: 2001})
+		if err := plan.verifyApproval(approvers); err == nil {
+			t.Fatal("Expected a plan modified after approval to be rejected")
+		}
+	})
+}
+
+func TestReadApproverKeys(t *testing.T) {
+	public, _ := testKey(t)
+	encoded := base64.StdEncoding.EncodeToString(public)
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		logins   []string
+		valid    bool
+	}{
+		{"empty", "", nil, true},
+		{"comments and blank lines", "# approvers\n\nbob " + encoded + "\n", []string{"bob"}, true},
+		{"logins are lowercased", "Bob " + encoded + "\nCAROL  " + encoded, []string{"bob", "carol"}, true},
+		{"missing key", "bob\n", nil, false},
+		{"extra fields", "bob " + encoded + " extra\n", nil, false},
+		{"not base64", "bob not-base64!\n", nil, false},
+		{"wrong key size", "bob " + base64.StdEncoding.EncodeToString(public[:16]) + "\n", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "approvers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err = f.WriteString(tc.contents); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			approvers, err := readApproverKeys(f.Name())
+			if !tc.valid {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(approvers) != len(tc.logins) {
+				t.Fatalf("Expected %d approvers, got %d", len(tc.logins), len(approvers))
+			}
+			for _, login := range tc.logins {
+				if !bytes.Equal(public, approvers[login]) {
+					t.Fatalf("Expected a key for %s", login)
+				}
+			}
+		})
+	}
+
+	if _, err := readApproverKeys(filepath.Join(os.TempDir(), "missing-approvers")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// rotationPolicy guards against rotating more than intended, whatever filters are given on
+// the command line. Entries are glob patterns matched against org/slug for pipelines and
+// org/name (or host/org/name) for repositories.
+type rotationPolicy struct {
+	// DenyPipelines and DenyRepositories are never rotated
+	DenyPipelines    []string `json:"deny_pipelines"`
+	DenyRepositories []string `json:"deny_repositories"`
+
+	// AllowPipelines and AllowRepositories are the only ones rotated, if either is given
+	AllowPipelines    []string `json:"allow_pipelines"`
+	AllowRepositories []string `json:"allow_repositories"`
+}
+
+func readRotationPolicy(filename string) (*rotationPolicy, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var policy rotationPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("Failed to parse policy %s: %v", filename, err)
+	}
+	for _, pattern := range append(append(append(policy.DenyPipelines, policy.DenyRepositories...),
+		policy.AllowPipelines...), policy.AllowRepositories...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q in policy %s: %v", pattern, filename, err)
+		}
+	}
+	return &policy, nil
+}
+
+// check returns why a pipeline can't be rotated, if the policy denies it or any of the
+// repositories it would edit hooks in. A nil policy allows everything.
+func (p *rotationPolicy) check(pipeline pipeline, matches []githubRepositoryHook) error {
+	if p == nil {
+		return nil
+	}
+
+	repos := []string{pipeline.Repository.String()}
+	for _, match := range matches {
+		repos = append(repos, match.githubRepository.String())
+	}
+
+	if matchesAny(p.DenyPipelines, pipeline.String()) {
+		return fmt.Errorf("Policy denies rotating %s", pipeline.String())
+	}
+	for _, repo := range repos {
+		if matchesAny(p.DenyRepositories, repo) {
+			return fmt.Errorf("Policy denies rotating hooks in %s", repo)
+		}
+	}
+
+	if len(p.AllowPipelines) == 0 && len(p.AllowRepositories) == 0 {
+		return nil
+	}
+	if matchesAny(p.AllowPipelines, pipeline.String()) {
+		return nil
+	}
+	for _, repo := range repos {
+		if !matchesAny(p.AllowRepositories, repo) {
+			return fmt.Errorf("Policy doesn't allow rotating %s", pipeline.String())
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
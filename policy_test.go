@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+func TestRotationPolicyCheck(t *testing.T) {
+	web := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}
+	api := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "api"}
+	infra := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "infra"}
+	ghe := githubRepository{Host: "ghe.example.com", Org: "platform", Name: "deploy"}
+
+	hooksIn := func(repos ...githubRepository) []githubRepositoryHook {
+		var matches []githubRepositoryHook
+		for i, repo := range repos {
+			matches = append(matches, githubRepositoryHook{repo, &github.Hook{ID: github.Int64(int64(1001 + i))}})
+		}
+		return matches
+	}
+	webPipeline := pipeline{Org: "acme", Slug: "web", Repository: web}
+	deployPipeline := pipeline{Org: "acme", Slug: "deploy", Repository: ghe}
+
+	for _, tc := range []struct {
+		name     string
+		policy   *rotationPolicy
+		pipeline pipeline
+		matches  []githubRepositoryHook
+		allowed  bool
+	}{
+		{"no policy", nil, webPipeline, hooksIn(web), true},
+		{"empty policy", &rotationPolicy{}, webPipeline, hooksIn(web), true},
+
+		{"denied pipeline", &rotationPolicy{DenyPipelines: []string{"acme/web"}}, webPipeline, hooksIn(web), false},
+		{"denied pipeline pattern", &rotationPolicy{DenyPipelines: []string{"acme/*"}}, webPipeline, hooksIn(web), false},
+		{"denied pipeline in another case", &rotationPolicy{DenyPipelines: []string{"ACME/Web"}}, webPipeline, hooksIn(web), false},
+		{"other pipeline denied", &rotationPolicy{DenyPipelines: []string{"acme/api"}}, webPipeline, hooksIn(web), true},
+		{"denied pipeline repository", &rotationPolicy{DenyRepositories: []string{"acme/web"}}, webPipeline, nil, false},
+		{"denied hook repository", &rotationPolicy{DenyRepositories: []string{"acme/infra"}}, webPipeline, hooksIn(web, infra), false},
+		{"denied enterprise repository", &rotationPolicy{DenyRepositories: []string{"ghe.example.com/platform/*"}}, deployPipeline, hooksIn(ghe), false},
+		{"enterprise repository isn't matched without its host", &rotationPolicy{DenyRepositories: []string{"platform/*"}}, deployPipeline, hooksIn(ghe), true},
+
+		{"allowed pipeline", &rotationPolicy{AllowPipelines: []string{"acme/web"}}, webPipeline, hooksIn(web, infra), true},
+		{"allowed pipeline in another case", &rotationPolicy{AllowPipelines: []string{"Acme/WEB"}}, webPipeline, hooksIn(web), true},
+		{"pipeline not allowed", &rotationPolicy{AllowPipelines: []string{"acme/api"}}, webPipeline, hooksIn(web), false},
+		{"allowed repositories", &rotationPolicy{AllowRepositories: []string{"acme/web", "acme/api"}}, webPipeline, hooksIn(web, api), true},
+		{"allowed repository pattern", &rotationPolicy{AllowRepositories: []string{"acme/*"}}, webPipeline, hooksIn(web, infra), true},
+		{"allowed repository in another case", &rotationPolicy{AllowRepositories: []string{"ACME/Web"}}, webPipeline, hooksIn(web), true},
+		{"one hook repository not allowed", &rotationPolicy{AllowRepositories: []string{"acme/web"}}, webPipeline, hooksIn(web, infra), false},
+		{"pipeline repository not allowed", &rotationPolicy{AllowRepositories: []string{"acme/api"}}, webPipeline, hooksIn(api), false},
+
+		{"allowed pipeline with repositories not allowed", &rotationPolicy{AllowPipelines: []string{"acme/web"}, AllowRepositories: []string{"acme/api"}}, webPipeline, hooksIn(web), true},
+		{"allowed repositories with pipeline not allowed", &rotationPolicy{AllowPipelines: []string{"acme/api"}, AllowRepositories: []string{"acme/web"}}, webPipeline, hooksIn(web), true},
+		{"deny wins over allowed pipeline", &rotationPolicy{AllowPipelines: []string{"acme/web"}, DenyPipelines: []string{"acme/web"}}, webPipeline, hooksIn(web), false},
+		{"deny wins over allowed repository", &rotationPolicy{AllowPipelines: []string{"acme/web"}, DenyRepositories: []string{"acme/infra"}}, webPipeline, hooksIn(web, infra), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.check(tc.pipeline, tc.matches)
+			if tc.allowed && err != nil {
+				t.Fatalf("Expected rotating to be allowed, got %v", err)
+			} else if !tc.allowed && err == nil {
+				t.Fatal("Expected rotating to be denied")
+			}
+		})
+	}
+}
+
+func TestReadRotationPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"valid", `{"deny_pipelines": ["acme/*"], "allow_repositories": ["acme/web"]}`, true},
+		{"empty", `{}`, true},
+		{"invalid json", `{"deny_pipelines": `, false},
+		{"invalid pattern", `{"allow_pipelines": ["acme/[web"]}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTempFile(t, tc.policy)
+			defer os.Remove(path)
+
+			_, err := readRotationPolicy(path)
+			if tc.valid && err != nil {
+				t.Fatalf("Expected the policy to be valid, got %v", err)
+			} else if !tc.valid && err == nil {
+				t.Fatal("Expected the policy to be invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildkiteWebhookHost is where github delivers webhook events for buildkite
+const buildkiteWebhookHost = "webhook.buildkite.com"
+
+// preflightTimeout is how long to wait to connect to each host
+const preflightTimeout = 10 * time.Second
+
+// preflightCheck is whether the runner could reach a host before rotating, so network problems
+// can be told apart from credential problems
+type preflightCheck struct {
+	Host      string `json:"host"`
+	Reachable bool   `json:"reachable"`
+	Proxy     string `json:"proxy,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// proxyAddr is the address to connect to a proxy at, with the default port of its scheme
+func proxyAddr(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	if proxy.Scheme == "https" {
+		return net.JoinHostPort(proxy.Hostname(), "443")
+	}
+	return net.JoinHostPort(proxy.Hostname(), "80")
+}
+
+// apiHosts are the hosts and ports of the github apis that hooks are edited through
+func (c *githubClients) apiHosts() []string {
+	var hosts []string
+	for host := range c.hostTokens {
+		if apiURL, ok := c.apiURLs[host]; ok {
+			if u, err := url.Parse(apiURL); err == nil {
+				hosts = append(hosts, u.Host)
+				continue
+			}
+		}
+		if host == defaultGithubHost {
+			host = "api.github.com"
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// preflight connects to each host with tls, without sending any requests. Hosts that are
+// reached through a proxy from the environment only have the proxy checked.
+func preflight(hosts []string) []preflightCheck {
+	var checks []preflightCheck
+	for _, host := range hosts {
+		addr := host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			addr = net.JoinHostPort(host, "443")
+		}
+
+		check := preflightCheck{Host: host, Reachable: true}
+		var conn net.Conn
+		var err error
+		if proxy, _ := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}}); proxy != nil {
+			check.Proxy = proxy.Host
+			conn, err = net.DialTimeout("tcp", proxyAddr(proxy), preflightTimeout)
+		} else {
+			conn, err = tls.DialWithDialer(&net.Dialer{Timeout: preflightTimeout}, "tcp", addr, nil)
+		}
+		if err != nil {
+			check.Reachable, check.Error = false, describeNetworkError(err)
+		} else {
+			conn.Close()
+			log.Printf("Reached %s", host)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// describeNetworkError says what kind of network problem an error is, for operators to
+// know where to look
+func describeNetworkError(err error) string {
+	switch e := err.(type) {
+	case *net.OpError:
+		if dnsErr, ok := e.Err.(*net.DNSError); ok {
+			return fmt.Sprintf("DNS lookup failed, %v", dnsErr)
+		}
+		if e.Timeout() {
+			return fmt.Sprintf("Timed out connecting after %v, a firewall or proxy may be blocking it", preflightTimeout)
+		}
+		if strings.Contains(e.Error(), "connection refused") {
+			return "Connection refused"
+		}
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+		return fmt.Sprintf("TLS certificate wasn't trusted, a proxy may be intercepting connections: %v", err)
+	case net.Error:
+		if e.Timeout() {
+			return fmt.Sprintf("Timed out connecting after %v, a firewall or proxy may be blocking it", preflightTimeout)
+		}
+	}
+	return err.Error()
+}
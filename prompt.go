@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Songmu/prompter"
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	answerYes           = "y"
+	answerNo            = "n"
+	answerAll           = "a"
+	answerSkipRemaining = "s"
+	answerQuit          = "q"
+	answerDetails       = "d"
+)
+
+// promptRotate asks whether to rotate a pipeline's webhook, showing details as many
+// times as they are asked for before returning one of the other answers
+func promptRotate(details func()) string {
+	for {
+		answer := (&prompter.Prompter{
+			Message:    "Rotate webhook? (y)es, (n)o, (a)ll remaining, (s)kip remaining, (q)uit, (d)etails",
+			Choices:    []string{answerYes, answerNo, answerAll, answerSkipRemaining, answerQuit, answerDetails},
+			IgnoreCase: true,
+			Default:    answerYes,
+		}).Prompt()
+
+		answer = strings.ToLower(answer)
+		if answer != answerDetails {
+			return answer
+		}
+
+		fmt.Fprintln(stdout)
+		details()
+		fmt.Fprintln(stdout)
+	}
+}
+
+// printHookDetails shows the configuration of the repository hooks that refer to a pipeline
+func printHookDetails(w io.Writer, p pipeline, matches []githubRepositoryHook, deliveries hookDeliveries) {
+	fmt.Fprintf(w, "Pipeline: https://buildkite.com/%s (%s)\n", p.String(), p.ID)
+	if len(matches) == 0 {
+		fmt.Fprintf(w, "\tNo matching hooks, only the buildkite webhook will be rotated\n")
+	}
+	for _, match := range matches {
+		fmt.Fprintf(w, "\tHook %s/settings/hooks/%d\n", match.githubRepository.URL(), *match.Hook.ID)
+		fmt.Fprintf(w, "\t\tURL:          %s\n", maskWebhookURL(hookURL(match.Hook)))
+		fmt.Fprintf(w, "\t\tActive:       %t\n", match.Hook.GetActive())
+		fmt.Fprintf(w, "\t\tEvents:       %s\n", strings.Join(match.Hook.Events, ", "))
+		fmt.Fprintf(w, "\t\tContent Type: %v\n", match.Hook.Config["content_type"])
+		fmt.Fprintf(w, "\t\tInsecure SSL: %v\n", match.Hook.Config["insecure_ssl"])
+		if match.Hook.CreatedAt != nil {
+			fmt.Fprintf(w, "\t\tCreated:      %s\n", match.Hook.GetCreatedAt())
+		}
+		if match.Hook.UpdatedAt != nil {
+			fmt.Fprintf(w, "\t\tUpdated:      %s\n", match.Hook.GetUpdatedAt())
+		}
+		if delivery, ok := deliveries.last(match.githubRepository, *match.Hook.ID); ok {
+			status := fmt.Sprintf("%d %s", delivery.StatusCode, delivery.Status)
+			if delivery.failed() {
+				status = color.RedString("%s, already failing", status)
+			}
+			fmt.Fprintf(w, "\t\tDelivered:    %s (%s)\n", delivery.DeliveredAt, status)
+		}
+	}
+}
+
+// printHookDiff shows the change that will be made to a repository hook's config. The new
+// url isn't known until the buildkite webhook is rotated, so it can be empty for a preview.
+func printHookDiff(w io.Writer, indent string, match githubRepositoryHook, newWebhookURL string, fixes hookFixes) {
+	newURL := "<rotated webhook url>"
+	if newWebhookURL != "" {
+		newURL = maskWebhookURL(newWebhookURL)
+	}
+
+	edit := &github.Hook{Config: map[string]interface{}{}}
+	fixes.apply(edit, match.Hook)
+
+	fmt.Fprintf(w, "%s--- %s/settings/hooks/%d\n", indent, match.githubRepository.URL(), *match.Hook.ID)
+	fmt.Fprintf(w, color.RedString("%s- url: %s\n"), indent, maskWebhookURL(hookURL(match.Hook)))
+	fmt.Fprintf(w, color.GreenString("%s+ url: %s\n"), indent, newURL)
+	if contentType, ok := edit.Config["content_type"]; ok {
+		fmt.Fprintf(w, color.RedString("%s- content_type: %s\n"), indent, hookContentType(match.Hook))
+		fmt.Fprintf(w, color.GreenString("%s+ content_type: %v\n"), indent, contentType)
+	} else {
+		fmt.Fprintf(w, "%s  content_type: %s\n", indent, hookContentType(match.Hook))
+	}
+	if insecureSSL, ok := edit.Config["insecure_ssl"]; ok {
+		fmt.Fprintf(w, color.RedString("%s- insecure_ssl: 1\n"), indent)
+		fmt.Fprintf(w, color.GreenString("%s+ insecure_ssl: %v\n"), indent, insecureSSL)
+	}
+	if edit.Events != nil {
+		fmt.Fprintf(w, color.RedString("%s- events: %s\n"), indent, strings.Join(match.Hook.Events, ", "))
+		fmt.Fprintf(w, color.GreenString("%s+ events: %s\n"), indent, strings.Join(edit.Events, ", "))
+	} else {
+		fmt.Fprintf(w, "%s  events: %s\n", indent, strings.Join(match.Hook.Events, ", "))
+	}
+	if edit.Active != nil {
+		fmt.Fprintf(w, color.RedString("%s- active: %t\n"), indent, match.Hook.GetActive())
+		fmt.Fprintf(w, color.GreenString("%s+ active: %t\n"), indent, edit.GetActive())
+	} else {
+		fmt.Fprintf(w, "%s  active: %t\n", indent, match.Hook.GetActive())
+	}
+}
+
+// promptToken asks for a token with echo disabled, if there's a terminal to ask at
+func promptToken(label string) string {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return ""
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// providerSettings are the github settings of a buildkite pipeline, which decide which
+// webhook events it builds from
+// https://buildkite.com/docs/apis/rest-api/pipelines#provider-settings-properties
+type providerSettings struct {
+	TriggerMode       string `json:"trigger_mode"`
+	BuildBranches     *bool  `json:"build_branches"`
+	BuildTags         bool   `json:"build_tags"`
+	BuildPullRequests bool   `json:"build_pull_requests"`
+	FilterEnabled     bool   `json:"filter_enabled,omitempty"`
+	FilterCondition   string `json:"filter_condition,omitempty"`
+}
+
+// getProviderSettings reads a pipeline's provider settings from the rest api, which accepts
+// the same api access tokens as graphql
+func getProviderSettings(token string, p pipeline) (providerSettings, error) {
+	req, err := http.NewRequest(http.MethodGet,
+		fmt.Sprintf("https://api.buildkite.com/v2/organizations/%s/pipelines/%s", p.Org, p.Slug), nil)
+	if err != nil {
+		return providerSettings{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return providerSettings{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return providerSettings{}, fmt.Errorf("Buildkite responded with %s", resp.Status)
+	}
+
+	var parsedResp struct {
+		Provider struct {
+			ID       string           `json:"id"`
+			Settings providerSettings `json:"settings"`
+		} `json:"provider"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsedResp); err != nil {
+		return providerSettings{}, fmt.Errorf("Failed to parse pipeline response: %v", err)
+	}
+	if parsedResp.Provider.ID != "github" {
+		return providerSettings{}, fmt.Errorf("Pipeline uses %q rather than github", parsedResp.Provider.ID)
+	}
+	return parsedResp.Provider.Settings, nil
+}
+
+// events are the github webhook events the pipeline needs to be delivered. Something is
+// amiss if this is empty, e.g the pipeline isn't triggered by github at all.
+func (s providerSettings) events() []string {
+	var events []string
+	switch s.TriggerMode {
+	case "", "code":
+		if s.BuildBranches == nil || *s.BuildBranches || s.BuildTags {
+			events = append(events, "push")
+		}
+		if s.BuildPullRequests {
+			events = append(events, "pull_request")
+		}
+	case "deployment":
+		events = append(events, "deployment")
+	}
+	sort.Strings(events)
+	return events
+}
+
+// mismatch describes how a hook's events are inconsistent with the settings, if they are
+func (s providerSettings) mismatch(hook *github.Hook) string {
+	events := s.events()
+	if len(events) == 0 {
+		return fmt.Sprintf("Pipeline isn't triggered by github (trigger mode %q) but has a hook", s.TriggerMode)
+	}
+	if !hookSubscribesToAll(hook) && !sameEvents(hook.Events, events) {
+		return fmt.Sprintf("Hook subscribes to %s but the pipeline builds from %s",
+			strings.Join(hook.Events, ", "), strings.Join(events, ", "))
+	}
+	return ""
+}
+
+// fetchProviderSettings reads the provider settings of every pipeline in an inventory that has
+// hooks, skipping any that can't be read with a warning
+func fetchProviderSettings(token string, inv *inventory, concurrency int) map[string]providerSettings {
+	settings := map[string]providerSettings{}
+	var mu sync.Mutex
+	g := newBoundedGroup(context.Background(), concurrency)
+
+	for _, p := range inv.Pipelines {
+		if len(inv.TokenHooks[p.WebhookToken]) == 0 {
+			continue
+		}
+		p := p
+		g.do(func(ctx context.Context) error {
+			s, err := getProviderSettings(token, p)
+			if err != nil {
+				log.Printf(color.YellowString("⚠️  Failed to read provider settings for https://buildkite.com/%s: %v",
+					p.String(), err))
+				return nil
+			}
+
+			mu.Lock()
+			settings[p.ID] = s
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	g.wait()
+	return settings
+}
+
+// sameEvents is whether a hook subscribes to exactly the given events
+func sameEvents(hookEvents, events []string) bool {
+	if len(hookEvents) != len(events) {
+		return false
+	}
+	sorted := append([]string{}, hookEvents...)
+	sort.Strings(sorted)
+	for i := range sorted {
+		if sorted[i] != events[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/cli/graphql"
+)
+
+const (
+	// pipelines are listed in pages of up to 100, which are halved down to 10 when
+	// buildkite says a page is too complex
+	maxPipelinePageSize = 100
+	minPipelinePageSize = 10
+
+	// maxGraphqlAttempts is how many times a rate limited or too complex request is retried
+	maxGraphqlAttempts = 5
+)
+
+// graphqlRateLimit tracks the complexity points used by graphql requests, from the rate
+// limit headers buildkite sends with each response
+// https://buildkite.com/docs/apis/graphql/graphql-resource-limits
+type graphqlRateLimit struct {
+	first, last int
+	limit       int
+	seen        bool
+
+	// cost is the complexity of the last request, and resetAt is when the limit resets
+	cost    int
+	resetAt time.Time
+}
+
+func (r *graphqlRateLimit) update(resp *graphql.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	if !r.seen {
+		r.first = remaining
+		r.seen = true
+	} else if r.last > remaining {
+		r.cost = r.last - remaining
+	}
+	r.last = remaining
+	r.limit, _ = strconv.Atoi(resp.Header.Get("RateLimit-Limit"))
+	if reset, err := strconv.Atoi(resp.Header.Get("RateLimit-Reset")); err == nil {
+		r.resetAt = time.Now().Add(time.Duration(reset) * time.Second)
+	}
+}
+
+// wait sleeps until the limit resets if there aren't enough points left for another
+// request like the last one
+func (r *graphqlRateLimit) wait() {
+	if !r.seen || r.cost == 0 || r.last >= r.cost {
+		return
+	}
+	if wait := time.Until(r.resetAt); wait > 0 {
+		log.Printf("Waiting %v for the Buildkite GraphQL rate limit to reset, %d complexity points remaining",
+			wait.Round(time.Second), r.last)
+		time.Sleep(wait)
+	}
+}
+
+// resetIn is how long to wait after being rate limited, which is until the limit resets
+// if buildkite said when, or a backoff that grows with each attempt
+func (r *graphqlRateLimit) resetIn(attempt int) time.Duration {
+	if wait := time.Until(r.resetAt); wait > 0 {
+		return wait.Round(time.Second)
+	}
+	return time.Duration(attempt*attempt) * 5 * time.Second
+}
+
+// isComplexityError is whether buildkite refused a query for being too complex
+func isComplexityError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "complexity")
+}
+
+// String summarizes the complexity used, or is empty if buildkite didn't send rate limit headers
+func (r graphqlRateLimit) String() string {
+	if !r.seen {
+		return ""
+	}
+	// the limit can reset part way through
+	if r.first < r.last {
+		return fmt.Sprintf(" (%d of %d complexity points remaining)", r.last, r.limit)
+	}
+	return fmt.Sprintf(", using at least %d complexity points (%d of %d remaining)", r.first-r.last, r.last, r.limit)
+}
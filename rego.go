@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// regoPolicy is an open policy agent policy that decides whether each rotation can go ahead,
+// evaluated with the opa cli so org-specific rules can be written in rego. The query should
+// produce an object with deny and confirm sets of reasons, e.g.
+//
+//	package buildkite.rotation
+//
+//	deny[msg] { input.webhook_age_days < 1; msg := "rotated in the last day" }
+//	confirm[msg] { input.pipeline.teams[_] == "payments"; msg := "owned by payments" }
+type regoPolicy struct {
+	path  string
+	query string
+}
+
+type regoInput struct {
+	Pipeline       regoPipeline `json:"pipeline"`
+	Repository     string       `json:"repository"`
+	Hooks          []regoHook   `json:"hooks"`
+	WebhookAgeDays float64      `json:"webhook_age_days"`
+}
+
+type regoPipeline struct {
+	ID         string   `json:"id"`
+	Slug       string   `json:"slug"`
+	Org        string   `json:"org"`
+	Teams      []string `json:"teams"`
+	Tags       []string `json:"tags"`
+	Cluster    string   `json:"cluster"`
+	Visibility string   `json:"visibility"`
+}
+
+type regoHook struct {
+	Repository string   `json:"repository"`
+	ID         int64    `json:"id"`
+	Active     bool     `json:"active"`
+	Events     []string `json:"events"`
+}
+
+type regoDecision struct {
+	Deny    []string `json:"deny"`
+	Confirm []string `json:"confirm"`
+}
+
+func newRegoInput(inv *inventory, p pipeline, matches []githubRepositoryHook) regoInput {
+	input := regoInput{
+		Pipeline: regoPipeline{
+			ID:         p.ID,
+			Slug:       p.Slug,
+			Org:        p.Org,
+			Teams:      p.Teams,
+			Tags:       p.Tags,
+			Cluster:    p.Cluster,
+			Visibility: p.Visibility,
+		},
+		Repository:     p.Repository.String(),
+		Hooks:          []regoHook{},
+		WebhookAgeDays: time.Since(webhookUpdatedAt(inv, p)).Hours() / 24,
+	}
+	for _, match := range matches {
+		input.Hooks = append(input.Hooks, regoHook{
+			Repository: match.githubRepository.String(),
+			ID:         match.Hook.GetID(),
+			Active:     match.Hook.GetActive(),
+			Events:     match.Hook.Events,
+		})
+	}
+	return input
+}
+
+func (r *regoPolicy) evaluate(input regoInput) (regoDecision, error) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return regoDecision{}, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("opa", "eval", "--format", "json", "--data", r.path, "--stdin-input", r.query)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return regoDecision{}, fmt.Errorf("Failed to evaluate %s: %v %s", r.path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value regoDecision `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return regoDecision{}, fmt.Errorf("Failed to parse opa output: %v", err)
+	}
+
+	// an undefined query has no results, which allows the rotation
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return regoDecision{}, nil
+	}
+	return result.Result[0].Expressions[0].Value, nil
+}
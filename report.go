@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	outcomeRotated = "rotated"
+	outcomePartial = "partial"
+	outcomeSkipped = "skipped"
+	outcomeFailed  = "failed"
+)
+
+// runReport is the structured result of a rotation run
+type runReport struct {
+	Organization string           `json:"organization"`
+	Version      string           `json:"version"`
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   time.Time        `json:"finished_at"`
+	Pipelines    []pipelineResult `json:"pipelines"`
+
+	// LegacyHooksRemaining is the number of hooks still pointing at webhook.buildbox.io
+	LegacyHooksRemaining int `json:"legacy_hooks_remaining"`
+
+	// UnparseableHooks are the hooks left alone because their urls can't be parsed
+	UnparseableHooks []unparseableHookResult `json:"unparseable_hooks,omitempty"`
+
+	// Groups are the combined results of pipelines that share a repository
+	Groups []repositoryGroupResult `json:"groups,omitempty"`
+
+	// Preflight is whether each host could be reached before rotating
+	Preflight []preflightCheck `json:"preflight,omitempty"`
+
+	// stream gets each result as it's added, if results are being streamed
+	stream *resultStream
+}
+
+type pipelineResult struct {
+	Pipeline   string       `json:"pipeline"`
+	PipelineID string       `json:"pipeline_id"`
+	Outcome    string       `json:"outcome"`
+	Reason     string       `json:"reason,omitempty"`
+	Teams      []string     `json:"teams,omitempty"`
+	Hooks      []hookResult `json:"hooks,omitempty"`
+
+	// WebhookRotated is whether the buildkite webhook was rotated, even if the pipeline failed
+	// afterwards, so a follow-up run knows only the hooks that failed are left to update
+	WebhookRotated bool `json:"webhook_rotated,omitempty"`
+
+	// fingerprints of the webhook tokens before and after rotating, see tokenFingerprint
+	OldTokenFingerprint string `json:"old_token_fingerprint,omitempty"`
+	NewTokenFingerprint string `json:"new_token_fingerprint,omitempty"`
+
+	// the id of the buildkite audit event for the rotation, if it was cross-checked
+	BuildkiteAuditEvent string `json:"buildkite_audit_event,omitempty"`
+
+	// the first webhook triggered build after rotation, if it was waited for
+	WebhookBuild string `json:"webhook_build,omitempty"`
+}
+
+type hookResult struct {
+	Repository string `json:"repository"`
+	ID         int64  `json:"id"`
+	Updated    bool   `json:"updated"`
+	Error      string `json:"error,omitempty"`
+
+	// the id of the hook created in place of this one, if it was deleted part way through
+	RecreatedID int64 `json:"recreated_id,omitempty"`
+
+	// the id of the github audit event for the update, if it was cross-checked
+	GithubAuditEvent string `json:"github_audit_event,omitempty"`
+}
+
+type unparseableHookResult struct {
+	Repository string `json:"repository"`
+	ID         int64  `json:"id"`
+	Reason     string `json:"reason"`
+}
+
+func newRunReport(org string) *runReport {
+	return &runReport{Organization: org, Version: versionString(), StartedAt: time.Now().UTC()}
+}
+
+func newPipelineResult(p pipeline, outcome, reason string) pipelineResult {
+	return pipelineResult{
+		Pipeline:   p.String(),
+		PipelineID: p.ID,
+		Teams:      p.Teams,
+		Outcome:    outcome,
+		Reason:     reason,
+	}
+}
+
+func (r *runReport) add(result pipelineResult) {
+	r.Pipelines = append(r.Pipelines, result)
+	r.stream.finish(result)
+}
+
+func (r *runReport) filename() string {
+	return fmt.Sprintf("report-%s.json", r.StartedAt.Format("20060102T150405Z"))
+}
+
+func (r *runReport) marshal() ([]byte, error) {
+	r.FinishedAt = time.Now().UTC()
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// updated is whether a hook was updated during the run
+func (r *runReport) updated(repo string, id int64) bool {
+	for _, result := range r.Pipelines {
+		for _, hook := range result.Hooks {
+			if hook.Repository == repo && hook.ID == id && hook.Updated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// failedHooks is the number of hooks that couldn't be updated for a pipeline
+func (pr pipelineResult) failedHooks() int {
+	failed := 0
+	for _, hook := range pr.Hooks {
+		if !hook.Updated {
+			failed++
+		}
+	}
+	return failed
+}
+
+// resultPublishers are where each pipeline's result is recorded once it's done, whether it
+// was rotated or its hooks were finished off after an earlier run
+type resultPublishers struct {
+	audit   *auditLog
+	events  *eventBridgePublisher
+	datadog *datadogPublisher
+}
+
+func (p *resultPublishers) publish(ctx context.Context, pl pipeline, result pipelineResult) {
+	org := pl.Org
+	if p.audit != nil {
+		if err := p.audit.record(org, result); err != nil {
+			log.Printf(color.RedString("🚨 Error writing audit log: %v", err))
+		}
+	}
+
+	if p.events != nil {
+		if err := p.events.publish(ctx, org, result); err != nil {
+			log.Printf(color.YellowString("⚠️  Failed to publish event to %s: %v", p.events.bus, err))
+		}
+	}
+
+	if p.datadog != nil {
+		if err := p.datadog.publish(ctx, org, pl, result); err != nil {
+			log.Printf(color.YellowString("⚠️  Failed to post event to Datadog: %v", err))
+		}
+	}
+}
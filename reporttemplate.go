@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// reportTemplate renders the result of a run with a user supplied text/template, for
+// producing the exact artifact a change process asks for, like a change ticket comment
+type reportTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// reportTemplateData is what templates are executed with, the run report along with
+// the same summary counts written for ci systems
+type reportTemplateData struct {
+	*runReport
+	Summary map[string]string
+}
+
+var reportTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"time": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+	"githubURL": githubURL,
+}
+
+// readReportTemplate parses a template up front, so mistakes in it are found before
+// anything is rotated
+func readReportTemplate(path string) (*reportTemplate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Funcs(reportTemplateFuncs).Option("missingkey=error").Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse report template %s: %v", path, err)
+	}
+	return &reportTemplate{name: strings.TrimSuffix(filepath.Base(path), ".tmpl"), tmpl: tmpl}, nil
+}
+
+func (t *reportTemplate) render(report *runReport) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, reportTemplateData{report, report.summary()}); err != nil {
+		return nil, fmt.Errorf("Failed to render report template %s: %v", t.tmpl.Name(), err)
+	}
+	return buf.Bytes(), nil
+}
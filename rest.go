@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// restPipeline is a pipeline as returned by the buildkite rest api
+// https://buildkite.com/docs/apis/rest-api/pipelines#list-pipelines
+type restPipeline struct {
+	GraphQLID  string     `json:"graphql_id"`
+	Slug       string     `json:"slug"`
+	WebURL     string     `json:"web_url"`
+	Repository string     `json:"repository"`
+	ClusterID  string     `json:"cluster_id"`
+	Tags       []string   `json:"tags"`
+	ArchivedAt *time.Time `json:"archived_at"`
+	Provider   struct {
+		ID         string `json:"id"`
+		WebhookURL string `json:"webhook_url"`
+	} `json:"provider"`
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+func restPipelineLister(token string) pipelineLister {
+	return func(org string, filter pipelineFilter) ([]pipeline, error) {
+		return listRESTPipelines(token, org, filter)
+	}
+}
+
+// listRESTPipelines lists pipelines with the rest api rather than graphql, for api access
+// tokens without graphql access. It feeds the same pipeline model, but clusters can only be
+// filtered by uuid and teams and last builds aren't known.
+func listRESTPipelines(token, org string, filter pipelineFilter) ([]pipeline, error) {
+	var pipelines []pipeline
+	var counts listingCounts
+
+	next := fmt.Sprintf("https://api.buildkite.com/v2/organizations/%s/pipelines?per_page=100", org)
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []restPipeline
+		if resp.StatusCode == http.StatusNotFound && counts.total == 0 {
+			err = errOrganizationNotFound(org)
+		} else if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("Buildkite responded with %s", resp.Status)
+		} else if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			err = fmt.Errorf("Failed to parse pipelines response: %v", err)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		next = ""
+		if match := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
+
+		for _, p := range page {
+			counts.total++
+			if !filter.matches(p.Slug, "", p.ClusterID, p.Tags) || (filter.ID != "" && p.GraphQLID != filter.ID) {
+				counts.filtered++
+				continue
+			}
+			if p.ArchivedAt != nil && !filter.IncludeArchived {
+				counts.archived++
+				continue
+			}
+			if p.Provider.ID != "github" && p.Provider.ID != "github_enterprise" {
+				counts.nonGithub++
+				continue
+			}
+			repo, err := parseGithubRepository(p.Repository)
+			if err != nil {
+				return nil, err
+			}
+			webhookToken, err := getWebhookToken(p.Provider.WebhookURL)
+			if err != nil {
+				return nil, err
+			}
+			pipelines = append(pipelines, pipeline{
+				ID:           p.GraphQLID,
+				URL:          p.WebURL,
+				Org:          org,
+				Slug:         p.Slug,
+				WebhookURL:   p.Provider.WebhookURL,
+				WebhookToken: webhookToken,
+				Repository:   repo,
+				Cluster:      p.ClusterID,
+				ClusterUUID:  p.ClusterID,
+				Tags:         p.Tags,
+			})
+		}
+	}
+
+	if counts.archived > 0 && len(pipelines) > 0 {
+		log.Printf("Skipping %d archived pipelines, use --include-archived to include them", counts.archived)
+	}
+	counts.explain(org, len(pipelines))
+	return pipelines, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiPolicy is how requests to one api are retried and timed out. GitHub and Buildkite
+// fail differently and have different rate limits, so they each get their own.
+type apiPolicy struct {
+	name    string
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+}
+
+// retryTransport applies the github or buildkite policy to each request depending on where
+// it's going, and leaves requests to anything else alone. Only requests that are safe to
+// repeat are retried, so a webhook is never rotated twice by a retry.
+type retryTransport struct {
+	base      http.RoundTripper
+	github    apiPolicy
+	buildkite apiPolicy
+}
+
+func (t *retryTransport) policy(req *http.Request) *apiPolicy {
+	host := req.URL.Hostname()
+	switch {
+	case host == "graphql.buildkite.com" || host == "api.buildkite.com":
+		return &t.buildkite
+	case host == "api.github.com" || strings.HasPrefix(req.URL.Path, "/api/v3/") || req.URL.Path == "/api/graphql":
+		return &t.github
+	}
+	return nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := t.policy(req)
+	if policy == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	// a request that isn't safe to repeat might have taken effect when it times out, like a
+	// rotation whose response was slow, so it's waited on for as long as it takes
+	retries, timeout := policy.retries, policy.timeout
+	if !repeatable(req) {
+		retries, timeout = 0, 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.WithContext(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.roundTrip(attemptReq, timeout)
+		if attempt >= retries || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := policy.backoff * time.Duration(1<<uint(attempt))
+		if err != nil {
+			log.Printf("Retrying %s request in %v: %v", policy.name, wait, err)
+		} else {
+			log.Printf("Retrying %s request in %v after %s", policy.name, wait, resp.Status)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// roundTrip makes a request with a timeout that lasts until its body has been read
+func (t *retryTransport) roundTrip(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout == 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// repeatable is whether a request can be sent again without changing the outcome. Rotating
+// a webhook is a graphql mutation, which is never repeated.
+func repeatable(req *http.Request) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		if !strings.HasSuffix(req.URL.Path, "graphql") && req.URL.Host != "graphql.buildkite.com" {
+			return false
+		}
+		if req.GetBody == nil {
+			return false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		defer body.Close()
+		b, err := ioutil.ReadAll(body)
+		return err == nil && !bytes.Contains(b, []byte("mutation"))
+	}
+	return false
+}
+
+// retryable is whether a request failed in a way that's worth trying again. Rate limits
+// are left to the callers, which know how long to wait for them to reset.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return err != context.Canceled
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
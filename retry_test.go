@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowTransport responds after a delay, unless the request's context finishes first
+type slowTransport struct {
+	delay time.Duration
+}
+
+func (t *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-time.After(t.delay):
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil)), Request: req}, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func TestRetryTransportTimeouts(t *testing.T) {
+	transport := &retryTransport{
+		base:      &slowTransport{delay: 50 * time.Millisecond},
+		buildkite: apiPolicy{name: "Buildkite", timeout: 10 * time.Millisecond},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		timesOut bool
+	}{
+		{"query", `{"query":"query { viewer { user { name } } }"}`, true},
+		{"mutation", `{"query":"mutation($input: PipelineRotateWebhookURLInput!) { pipelineRotateWebhookURL(input: $input) { pipeline { webhookURL } } }"}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "https://graphql.buildkite.com/v1", strings.NewReader(tc.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if tc.timesOut && err == nil {
+				t.Fatal("Expected the request to time out")
+			} else if !tc.timesOut && err != nil {
+				t.Fatalf("Expected the request not to time out, got %v", err)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
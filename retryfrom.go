@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// failedPipelines returns the pipelines that failed or were only partly rotated in a
+// previous run, by id
+func failedPipelines(report *runReport) map[string]pipelineResult {
+	failed := map[string]pipelineResult{}
+	for _, result := range report.Pipelines {
+		if result.Outcome == outcomeFailed || result.Outcome == outcomePartial {
+			failed[result.PipelineID] = result
+		}
+	}
+	return failed
+}
+
+// finishHooks updates the hooks that couldn't be updated after a pipeline's webhook was
+// rotated in a previous run. Those hooks still have the old webhook url, so they won't match
+// the pipeline anymore and are found by their ids instead.
+func (r *rotator) finishHooks(ctx context.Context, p pipeline, previous pipelineResult) pipelineResult {
+	result := newPipelineResult(p, outcomeRotated, "")
+	result.WebhookRotated = true
+	result.OldTokenFingerprint = previous.OldTokenFingerprint
+	result.NewTokenFingerprint = tokenFingerprint(p.WebhookToken)
+
+	for _, previousHook := range previous.Hooks {
+		if previousHook.Updated {
+			continue
+		}
+		hookResult := hookResult{Repository: previousHook.Repository, ID: previousHook.ID}
+
+		err := r.finishHook(ctx, p, previousHook)
+		if err != nil {
+			log.Printf(color.RedString("🚨 Error updating github webhook: %v", err))
+			hookResult.Error = err.Error()
+		} else {
+			hookResult.Updated = true
+		}
+		result.Hooks = append(result.Hooks, hookResult)
+	}
+
+	if result.failedHooks() > 0 {
+		result.Outcome = outcomePartial
+	}
+	return result
+}
+
+func (r *rotator) finishHook(ctx context.Context, p pipeline, previousHook hookResult) error {
+	if err := validateWebhookURL(p.WebhookURL); err != nil {
+		return err
+	}
+	repo, err := parseRepositoryName(previousHook.Repository)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := r.provider.ListHooks(ctx, repo)
+	if err != nil {
+		return err
+	}
+	var hook *github.Hook
+	for _, h := range hooks {
+		if h.GetID() == previousHook.ID {
+			hook = h
+		}
+	}
+	if hook == nil {
+		return fmt.Errorf("%s/settings/hooks/%d no longer exists", repo.URL(), previousHook.ID)
+	}
+
+	match := githubRepositoryHook{repo, hook}
+	if hookURL(hook) == p.WebhookURL {
+		log.Printf("%s/settings/hooks/%d already has the new webhook", repo.URL(), previousHook.ID)
+		return nil
+	}
+
+	log.Printf("Updating %s/settings/hooks/%d", repo.URL(), previousHook.ID)
+	printHookDiff(stdout, "\t", match, p.WebhookURL, hookFixes{})
+	match, err = r.updateHook(ctx, match, p.WebhookURL, hookFixes{})
+	if err != nil {
+		return err
+	}
+	if r.verifyPing {
+		return r.verifyPingFor(ctx, match, hook, p.WebhookURL)
+	}
+	return nil
+}
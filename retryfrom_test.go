@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+func TestFailedPipelines(t *testing.T) {
+	report := &runReport{Pipelines: []pipelineResult{
+		{PipelineID: "rotated", Outcome: outcomeRotated},
+		{PipelineID: "partial", Outcome: outcomePartial, WebhookRotated: true},
+		{PipelineID: "skipped", Outcome: outcomeSkipped},
+		{PipelineID: "failed", Outcome: outcomeFailed},
+		{PipelineID: "failed-after-rotating", Outcome: outcomeFailed, WebhookRotated: true},
+	}}
+
+	var ids []string
+	for id := range failedPipelines(report) {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if want := []string{"failed", "failed-after-rotating", "partial"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Expected %v to be retried, got %v", want, ids)
+	}
+}
+
+// editableProvider is a repository provider that records the urls hooks are updated to,
+// failing updates to some hooks
+type editableProvider struct {
+	hooks   []*github.Hook
+	failing map[int64]bool
+	updated map[int64]string
+}
+
+func (p *editableProvider) ListHooks(ctx context.Context, repo githubRepository) ([]*github.Hook, error) {
+	return p.hooks, nil
+}
+
+func (p *editableProvider) MatchHook(hook *github.Hook) bool {
+	return true
+}
+
+func (p *editableProvider) UpdateHook(ctx context.Context, match githubRepositoryHook, webhookURL string, fixes hookFixes) error {
+	if p.failing[*match.Hook.ID] {
+		return errors.New("Validation Failed")
+	}
+	p.updated[*match.Hook.ID] = webhookURL
+	return nil
+}
+
+func (p *editableProvider) CreateHook(ctx context.Context, repo githubRepository, hook *github.Hook) (*github.Hook, error) {
+	return nil, errors.New("Hooks can't be created")
+}
+
+func TestFinishHooks(t *testing.T) {
+	const (
+		oldWebhookURL = "https://webhook.buildkite.com/deliver/old"
+		newWebhookURL = "https://webhook.buildkite.com/deliver/new"
+	)
+	hook := func(id int64, url string) *github.Hook {
+		return &github.Hook{ID: github.Int64(id), Config: map[string]interface{}{"url": url}}
+	}
+	previous := pipelineResult{
+		PipelineID:          "web-id",
+		Outcome:             outcomePartial,
+		WebhookRotated:      true,
+		OldTokenFingerprint: tokenFingerprint("old"),
+		Hooks: []hookResult{
+			{Repository: "acme/web", ID: 1001, Updated: true},
+			{Repository: "acme/web", ID: 1002, Error: "Bad Gateway"},
+			{Repository: "acme/web", ID: 1003, Error: "Bad Gateway"},
+			{Repository: "acme/web", ID: 1004, Error: "Bad Gateway"},
+			{Repository: "acme/web", ID: 1005, Error: "Bad Gateway"},
+		},
+	}
+	p := pipeline{ID: "web-id", Org: "acme", Slug: "web", WebhookURL: newWebhookURL, WebhookToken: "new"}
+
+	provider := &editableProvider{
+		hooks: []*github.Hook{
+			hook(1001, newWebhookURL),
+			hook(1002, oldWebhookURL),
+			hook(1003, newWebhookURL),
+			hook(1004, oldWebhookURL),
+		},
+		failing: map[int64]bool{1004: true},
+		updated: map[int64]string{},
+	}
+	r := &rotator{provider: provider}
+	result := r.finishHooks(context.Background(), p, previous)
+
+	if want := map[int64]string{1002: newWebhookURL}; !reflect.DeepEqual(provider.updated, want) {
+		t.Fatalf("Expected updates %v, got %v", want, provider.updated)
+	}
+	if !result.WebhookRotated || result.Outcome != outcomePartial {
+		t.Fatalf("Expected a partial rotation, got %s", result.Outcome)
+	}
+	if result.OldTokenFingerprint != previous.OldTokenFingerprint || result.NewTokenFingerprint != tokenFingerprint("new") {
+		t.Fatal("Expected the token fingerprints of the earlier rotation")
+	}
+
+	// hooks that were already updated by the earlier run aren't in the result
+	updated := map[int64]bool{}
+	for _, h := range result.Hooks {
+		updated[h.ID] = h.Updated
+	}
+	if want := map[int64]bool{1002: true, 1003: true, 1004: false, 1005: false}; !reflect.DeepEqual(updated, want) {
+		t.Fatalf("Expected hooks updated %v, got %v", want, updated)
+	}
+}
+
+func TestFinishHooksInvalidWebhookURL(t *testing.T) {
+	provider := &editableProvider{
+		hooks:   []*github.Hook{{ID: github.Int64(1001), Config: map[string]interface{}{"url": "https://webhook.buildkite.com/deliver/old"}}},
+		updated: map[int64]string{},
+	}
+	r := &rotator{provider: provider}
+	p := pipeline{ID: "web-id", Org: "acme", Slug: "web", WebhookURL: "https://example.com/not-a-webhook"}
+	previous := pipelineResult{PipelineID: "web-id", Outcome: outcomeFailed, WebhookRotated: true,
+		Hooks: []hookResult{{Repository: "acme/web", ID: 1001, Error: "Not updated"}}}
+
+	result := r.finishHooks(context.Background(), p, previous)
+	if len(provider.updated) > 0 {
+		t.Fatalf("Expected no hooks to be updated with an invalid url, got %v", provider.updated)
+	}
+	if result.Outcome != outcomePartial || result.failedHooks() != 1 {
+		t.Fatalf("Expected the hook to still need fixing, got %s with %d failed", result.Outcome, result.failedHooks())
+	}
+}
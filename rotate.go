@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/buildkite/cli/graphql"
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// rotator rotates pipeline webhooks in buildkite and applies them to github
+type rotator struct {
+	client             *graphql.Client
+	apiToken           string
+	ghClients          *githubClients
+	provider           repositoryProvider
+	skipPermissionTest bool
+	postStatus         bool
+	openIssues         bool
+	issuesRepo         string
+	verifyPing         bool
+	rollbackPing       bool
+	confirmBuild       time.Duration
+	backup             *hookBackup
+	fixes              hookFixes
+
+	// testedRepos are repositories whose hooks are known to be editable, so they aren't
+	// tested again before each rotation
+	testedRepos map[string]bool
+
+	// githubFailures counts hook updates that have failed in a row, and once it reaches
+	// maxGithubFailures no more pipelines are rotated
+	githubFailures    int
+	maxGithubFailures int
+
+	// hookRetries is how many times to retry a failed hook update before leaving the
+	// hook for a follow-up run and moving on to the next pipeline
+	hookRetries int
+}
+
+// hookRetryBackoff is how long to wait before retrying a hook update, growing with each attempt
+const hookRetryBackoff = 5 * time.Second
+
+// tripped is whether so many github updates have failed in a row that rotating more
+// pipelines would only revoke webhooks that can't be propagated, like when the token was
+// revoked part way through a run
+func (r *rotator) tripped() bool {
+	return r.maxGithubFailures > 0 && r.githubFailures >= r.maxGithubFailures
+}
+
+// fixesFor returns the fixes to apply to a pipeline's hooks, looking up the events it
+// builds from in its provider settings
+func (r *rotator) fixesFor(pipeline pipeline) hookFixes {
+	fixes := r.fixes
+	settings, err := getProviderSettings(r.apiToken, pipeline)
+	if err != nil {
+		log.Printf(color.YellowString("⚠️  Failed to read provider settings for https://buildkite.com/%s: %v",
+			pipeline.String(), err))
+		return fixes
+	}
+	fixes.Events = settings.events()
+	return fixes
+}
+
+// verifyPingFor checks buildkite accepts a ping to an updated hook, undoing any config fixes
+// made to it if it doesn't and rolling back was asked for
+func (r *rotator) verifyPingFor(ctx context.Context, updated githubRepositoryHook, previous *github.Hook, webhookURL string) error {
+	ghClient, err := r.ghClients.clientFor(updated.githubRepository)
+	if err != nil {
+		return err
+	}
+	err = verifyHookPing(ctx, ghClient, updated)
+	if err != nil && r.rollbackPing {
+		log.Printf("Restoring the previous config of %s/settings/hooks/%d, keeping the new webhook",
+			updated.githubRepository.URL(), *updated.Hook.ID)
+		if rollbackErr := restoreGithubRepositoryHook(ctx, ghClient, updated, previous, webhookURL); rollbackErr != nil {
+			return fmt.Errorf("%v, and failed to restore the hook: %v", err, rollbackErr)
+		}
+	} else if err == nil {
+		log.Printf("Buildkite accepted a ping to the updated hook")
+	}
+	return err
+}
+
+// updateHook updates a hook to the new webhook, retrying it if that's been asked for. A hook
+// that was deleted since it was listed is created again, and the hook that was updated or
+// created is returned.
+func (r *rotator) updateHook(ctx context.Context, match githubRepositoryHook, newWebhookURL string, fixes hookFixes) (githubRepositoryHook, error) {
+	for attempt := 1; ; attempt++ {
+		err := r.provider.UpdateHook(ctx, match, newWebhookURL, fixes)
+		if isNotFound(err) {
+			return r.recreateDeletedHook(ctx, match, newWebhookURL, fixes, err)
+		}
+		if _, modified := err.(*hookModifiedError); err == nil || modified || attempt > r.hookRetries {
+			return match, err
+		}
+		log.Printf(color.YellowString("⚠️  Error updating github webhook, retrying (%d of %d): %v", attempt, r.hookRetries, err))
+		time.Sleep(time.Duration(attempt) * hookRetryBackoff)
+	}
+}
+
+// recreateDeletedHook creates a hook again after github said it wasn't found, once listing
+// the repository's hooks confirms it's gone. Github also responds with 404 when the token
+// doesn't have admin access to a repository, which is left as an error.
+func (r *rotator) recreateDeletedHook(ctx context.Context, match githubRepositoryHook, newWebhookURL string, fixes hookFixes, notFound error) (githubRepositoryHook, error) {
+	hooks, err := r.provider.ListHooks(ctx, match.githubRepository)
+	if err != nil {
+		return match, fmt.Errorf("Hook wasn't found and the repository's hooks can't be listed, "+
+			"the token may not have admin access to %s: %v", match.githubRepository.String(), err)
+	}
+	for _, hook := range hooks {
+		if hook.GetID() == *match.Hook.ID {
+			return match, fmt.Errorf("Hook wasn't found when editing it but is still listed, "+
+				"the token may not have admin access to %s: %v", match.githubRepository.String(), notFound)
+		}
+	}
+	return r.recreateHook(ctx, match, newWebhookURL, fixes)
+}
+
+// recreateHook creates a hook in place of one that was deleted, with the same config and
+// events as the original and any fixes applied
+func (r *rotator) recreateHook(ctx context.Context, match githubRepositoryHook, newWebhookURL string, fixes hookFixes) (githubRepositoryHook, error) {
+	log.Printf(color.YellowString("⚠️  %s/settings/hooks/%d has been deleted, creating a new hook",
+		match.githubRepository.URL(), *match.Hook.ID))
+
+	insecureSSL := "0"
+	if hookInsecureSSL(match.Hook) {
+		insecureSSL = "1"
+	}
+	create := &github.Hook{
+		Active: github.Bool(match.Hook.GetActive()),
+		Events: match.Hook.Events,
+		Config: map[string]interface{}{
+			"url":          newWebhookURL,
+			"content_type": hookContentType(match.Hook),
+			"insecure_ssl": insecureSSL,
+		},
+	}
+	fixes.apply(create, match.Hook)
+	if len(create.Events) == 0 && len(fixes.Events) > 0 {
+		create.Events = fixes.Events
+	}
+
+	hook, err := r.provider.CreateHook(ctx, match.githubRepository, create)
+	if err != nil {
+		return match, fmt.Errorf("Hook was deleted and creating a new one failed: %v", err)
+	}
+
+	log.Printf("Created %s/settings/hooks/%d", match.githubRepository.URL(), hook.GetID())
+	return githubRepositoryHook{match.githubRepository, hook}, nil
+}
+
+// isNotFound is whether github responded to a request with 404 Not Found
+func isNotFound(err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
+
+// openIssue opens an issue about a hook that couldn't be updated, on the hook's repository
+// or the central issues repository, with whichever token or app installation can access it
+func (r *rotator) openIssue(ctx context.Context, match githubRepositoryHook, p pipeline, updateErr error) (*github.Issue, error) {
+	repo, centralRepo := match.githubRepository, ""
+	if r.issuesRepo != "" {
+		var err error
+		if repo, err = parseRepositoryName(r.issuesRepo); err != nil {
+			return nil, err
+		}
+		centralRepo = repo.Org + "/" + repo.Name
+	}
+	ghClient, err := r.ghClients.clientFor(repo)
+	if err != nil {
+		return nil, err
+	}
+	return openHookFailureIssue(ctx, ghClient, centralRepo, match, p, updateErr)
+}
+
+// rotate rotates a pipeline's webhook and updates the repository hooks that refer to it. The
+// result records which hooks were updated, and those that failed need a manual fix.
+func (r *rotator) rotate(ctx context.Context, pipeline pipeline, matches []githubRepositoryHook, fixes hookFixes) (rotation, pipelineResult, error) {
+	result := newPipelineResult(pipeline, outcomeFailed, "")
+	result.OldTokenFingerprint = tokenFingerprint(pipeline.WebhookToken)
+	for _, match := range matches {
+		result.Hooks = append(result.Hooks, hookResult{
+			Repository: match.githubRepository.String(),
+			ID:         *match.Hook.ID,
+		})
+	}
+
+	fail := func(err error) (rotation, pipelineResult, error) {
+		result.Reason = err.Error()
+		return rotation{}, result, err
+	}
+
+	// keep a snapshot of the hooks before anything is edited
+	if r.backup != nil && len(matches) > 0 {
+		if err := r.backup.add(pipeline, matches); err != nil {
+			return fail(err)
+		}
+	}
+
+	if len(matches) > 0 && !r.skipPermissionTest && !r.testedRepos[matches[0].githubRepository.String()] {
+		// first off try updating it to the current value as a test
+		if err := r.provider.UpdateHook(ctx, matches[0], pipeline.WebhookURL, hookFixes{}); err != nil {
+			return fail(fmt.Errorf("Can't update repository webhooks, permissions perhaps? %v", err))
+		}
+
+		log.Printf("Successfully tested updating github webhook")
+	}
+
+	rotatedAt := time.Now()
+	newWebhookURL, err := rotateBuildkiteWebhook(r.client, pipeline.ID)
+	if invalid, ok := err.(*invalidWebhookURLError); ok {
+		// the old webhook has been revoked, so a follow-up run needs to finish the hooks
+		result.WebhookRotated = true
+		for i := range result.Hooks {
+			result.Hooks[i].Error = "Not updated, " + invalid.Error()
+		}
+		return fail(fmt.Errorf("Rotated, but hooks weren't updated: %v", invalid))
+	} else if err != nil {
+		return fail(fmt.Errorf("Error rotating buildkite webhooks: %v", err))
+	}
+
+	log.Printf("New buildkite webhook is %s", newWebhookURL)
+	result.WebhookRotated = true
+	if token, err := getWebhookToken(newWebhookURL); err == nil {
+		result.NewTokenFingerprint = tokenFingerprint(token)
+	}
+
+	// apply the new webhook to all the matching repository hooks
+	for i, match := range matches {
+		log.Printf("Updating %s/settings/hooks/%d", match.githubRepository.URL(), *match.Hook.ID)
+		printHookDiff(stdout, "\t", match, newWebhookURL, fixes)
+		updated, err := r.updateHook(ctx, match, newWebhookURL, fixes)
+		if updated.Hook != match.Hook {
+			result.Hooks[i].RecreatedID = updated.GetID()
+		}
+		if err != nil {
+			r.githubFailures++
+		} else {
+			r.githubFailures = 0
+		}
+		if err == nil && r.verifyPing {
+			err = r.verifyPingFor(ctx, updated, match.Hook, newWebhookURL)
+		}
+		if err != nil {
+			result.Hooks[i].Error = err.Error()
+		} else {
+			result.Hooks[i].Updated = true
+		}
+		// hooks that still fail after retrying are left for a follow-up run
+		if err != nil && !r.openIssues && r.hookRetries == 0 {
+			return fail(fmt.Errorf("Error updating github webhook: %v", err))
+		} else if err != nil {
+			log.Printf(color.RedString("🚨 Error updating github webhook: %v", err))
+		}
+
+		if err != nil && r.openIssues {
+			issue, err := r.openIssue(ctx, match, pipeline, err)
+			if err != nil {
+				log.Printf(color.RedString("🚨 Error opening issue: %v", err))
+			} else {
+				log.Printf("Opened %s", issue.GetHTMLURL())
+			}
+		}
+	}
+
+	// let repository owners know about the change in context
+	if r.postStatus {
+		posted := map[string]bool{}
+		for _, match := range matches {
+			if posted[match.githubRepository.String()] {
+				continue
+			}
+			posted[match.githubRepository.String()] = true
+			ghClient, err := r.ghClients.clientFor(match.githubRepository)
+			if err == nil {
+				err = postRotationStatus(ctx, ghClient, match.githubRepository, pipeline)
+			}
+			if err != nil {
+				log.Printf(color.YellowString("⚠️  Failed to post status to %s: %v", match.githubRepository.URL(), err))
+			}
+		}
+	}
+
+	result.Outcome = outcomeRotated
+	if result.failedHooks() > 0 {
+		result.Outcome = outcomePartial
+	}
+
+	// prove the new webhook works end-to-end before moving on
+	if r.confirmBuild > 0 && len(matches) > result.failedHooks() {
+		build, err := waitForWebhookBuild(r.client, pipeline, rotatedAt, r.confirmBuild)
+		if err != nil {
+			result.Reason = err.Error()
+			log.Printf(color.YellowString("⚠️  %v", err))
+		} else {
+			result.WebhookBuild = build.URL
+			log.Printf("Confirmed webhook triggered build %s", build.URL)
+		}
+	}
+
+	return rotation{pipeline, pipeline.WebhookURL, newWebhookURL}, result, nil
+}
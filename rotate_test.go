@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/cli/graphql"
+	"github.com/google/go-github/v25/github"
+)
+
+// fakeProvider is a repository provider whose hooks can't be edited, like when they've been
+// deleted or the token doesn't have access
+type fakeProvider struct {
+	hooks   []*github.Hook
+	listErr error
+	created []*github.Hook
+	updates int
+}
+
+func (p *fakeProvider) ListHooks(ctx context.Context, repo githubRepository) ([]*github.Hook, error) {
+	return p.hooks, p.listErr
+}
+
+func (p *fakeProvider) MatchHook(hook *github.Hook) bool {
+	return true
+}
+
+func (p *fakeProvider) UpdateHook(ctx context.Context, match githubRepositoryHook, webhookURL string, fixes hookFixes) error {
+	p.updates++
+	return &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}, Message: "Not Found"}
+}
+
+func (p *fakeProvider) CreateHook(ctx context.Context, repo githubRepository, hook *github.Hook) (*github.Hook, error) {
+	created := *hook
+	created.ID = github.Int64(9001)
+	p.created = append(p.created, &created)
+	return &created, nil
+}
+
+func TestUpdateDeletedHook(t *testing.T) {
+	const newWebhookURL = "https://webhook.buildkite.com/deliver/new"
+	original := &github.Hook{
+		ID:     github.Int64(1001),
+		Active: github.Bool(false),
+		Events: []string{"push"},
+		Config: map[string]interface{}{
+			"url":          "https://webhook.buildkite.com/deliver/old",
+			"content_type": "form",
+			"insecure_ssl": "1",
+		},
+	}
+	match := githubRepositoryHook{githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}, original}
+
+	for _, tc := range []struct {
+		name     string
+		provider *fakeProvider
+		fixes    hookFixes
+		want     *github.Hook
+	}{
+		{
+			name:     "still listed",
+			provider: &fakeProvider{hooks: []*github.Hook{original}},
+		},
+		{
+			name:     "hooks can't be listed",
+			provider: &fakeProvider{listErr: errors.New("404 Not Found")},
+		},
+		{
+			name:     "deleted",
+			provider: &fakeProvider{},
+			want: &github.Hook{
+				Active: github.Bool(false),
+				Events: []string{"push"},
+				Config: map[string]interface{}{"url": newWebhookURL, "content_type": "form", "insecure_ssl": "1"},
+			},
+		},
+		{
+			name:     "deleted with fixes",
+			provider: &fakeProvider{},
+			fixes:    hookFixes{ContentType: true, InsecureSSL: true, Reactivate: true, AlignEvents: true, Events: []string{"push", "pull_request"}},
+			want: &github.Hook{
+				Active: github.Bool(true),
+				Events: []string{"push", "pull_request"},
+				Config: map[string]interface{}{"url": newWebhookURL, "content_type": "json", "insecure_ssl": "0"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &rotator{provider: tc.provider}
+			updated, err := r.updateHook(context.Background(), match, newWebhookURL, tc.fixes)
+
+			if tc.want == nil {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				if len(tc.provider.created) > 0 {
+					t.Fatal("Expected no hook to be created")
+				}
+				if updated.Hook != original {
+					t.Fatal("Expected the original hook to be returned")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tc.provider.created) != 1 {
+				t.Fatalf("Expected a hook to be created, got %d", len(tc.provider.created))
+			}
+			created := *tc.provider.created[0]
+			created.ID = nil
+			if !reflect.DeepEqual(&created, tc.want) {
+				t.Fatalf("Expected %v to be created, got %v", tc.want, &created)
+			}
+			if updated.GetID() != 9001 {
+				t.Fatalf("Expected the created hook to be returned, got %d", updated.GetID())
+			}
+		})
+	}
+}
+
+func TestUpdatedHooksFollowRecreatedHooks(t *testing.T) {
+	report := &runReport{Pipelines: []pipelineResult{{Hooks: []hookResult{
+		{Repository: "acme/web", ID: 1001, Updated: true},
+		{Repository: "acme/web", ID: 1002, Updated: true, RecreatedID: 9001},
+		{Repository: "acme/api", ID: 2001, Error: "Not Found"},
+	}}}}
+
+	var ids []int64
+	for _, hook := range updatedHooks(report) {
+		ids = append(ids, hook.GetID())
+	}
+	if want := []int64{1001, 9001}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Expected hooks %v to be watched, got %v", want, ids)
+	}
+}
+
+// testRotateClient is a graphql client for a test server that rotates webhooks to a url
+func testRotateClient(t *testing.T, webhookURL string) (*graphql.Client, func()) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"pipelineRotateWebhookURL":{"pipeline":{"webhookURL":%q}}}}`, webhookURL)
+	}))
+	client, err := graphql.NewClientWithEndpoint("token", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+func TestRotateInvalidWebhookURL(t *testing.T) {
+	client, done := testRotateClient(t, "https://example.com/not-a-webhook")
+	defer done()
+
+	provider := &fakeProvider{}
+	r := &rotator{client: client, provider: provider, skipPermissionTest: true}
+	repo := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}
+	p := pipeline{ID: "web-id", Org: "acme", Slug: "web", Repository: repo,
+		WebhookURL: "https://webhook.buildkite.com/deliver/old", WebhookToken: "old"}
+	matches := []githubRepositoryHook{{repo, &github.Hook{ID: github.Int64(1001)}}}
+
+	_, result, err := r.rotate(context.Background(), p, matches, hookFixes{})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if provider.updates > 0 {
+		t.Fatal("Expected no hooks to be updated with the invalid url")
+	}
+	if !result.WebhookRotated {
+		t.Fatal("Expected the webhook to be recorded as rotated")
+	}
+	if result.Outcome != outcomeFailed || !strings.HasPrefix(result.Reason, "Rotated, but hooks weren't updated") {
+		t.Fatalf("Expected a failed rotation explaining the hooks weren't updated, got %s: %s", result.Outcome, result.Reason)
+	}
+	if len(result.Hooks) != 1 || result.Hooks[0].Updated || result.Hooks[0].Error == "" {
+		t.Fatalf("Expected the hook to be recorded as not updated, got %+v", result.Hooks)
+	}
+
+	// a follow-up run finishes the hooks rather than rotating again
+	if _, ok := failedPipelines(&runReport{Pipelines: []pipelineResult{result}})[p.ID]; !ok {
+		t.Fatal("Expected the pipeline to be retried")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// repositoryProvider is a source code host that repository hooks live on. Hooks are
+// represented with github's types, which other providers convert to and from.
+type repositoryProvider interface {
+	// ListHooks returns the hooks in a repository that deliver to buildkite, and any whose
+	// url can't be parsed to tell
+	ListHooks(ctx context.Context, repo githubRepository) ([]*github.Hook, error)
+
+	// MatchHook is whether a hook delivers to buildkite
+	MatchHook(hook *github.Hook) bool
+
+	// UpdateHook points a hook at a webhook url, applying any fixes to its config
+	UpdateHook(ctx context.Context, match githubRepositoryHook, webhookURL string, fixes hookFixes) error
+
+	// CreateHook adds a hook to a repository, returning it as it was created
+	CreateHook(ctx context.Context, repo githubRepository, hook *github.Hook) (*github.Hook, error)
+}
+
+// githubProvider is github.com and github enterprise server
+type githubProvider struct {
+	clients *githubClients
+
+	// unchecked edits hooks without reading them first to check for concurrent changes
+	unchecked bool
+}
+
+func (p *githubProvider) ListHooks(ctx context.Context, repo githubRepository) ([]*github.Hook, error) {
+	client, err := p.clients.clientFor(repo)
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := getGithubRepositoryWebhooks(ctx, client, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildkiteHooks []*github.Hook
+	for _, hook := range hooks {
+		if p.MatchHook(hook) || unparseableReason(hook) != "" {
+			buildkiteHooks = append(buildkiteHooks, hook)
+		}
+	}
+	return buildkiteHooks, nil
+}
+
+func (p *githubProvider) MatchHook(hook *github.Hook) bool {
+	webhookURL := hookURL(hook)
+	return strings.Contains(webhookURL, "webhook.buildbox.io") || strings.Contains(webhookURL, "webhook.buildkite.com")
+}
+
+func (p *githubProvider) UpdateHook(ctx context.Context, match githubRepositoryHook, webhookURL string, fixes hookFixes) error {
+	client, err := p.clients.clientFor(match.githubRepository)
+	if err != nil {
+		return err
+	}
+	if p.unchecked {
+		return editGithubRepositoryHook(ctx, client, match, webhookURL, fixes)
+	}
+	return updateGithubRepositoryHook(ctx, client, match, webhookURL, fixes)
+}
+
+func (p *githubProvider) CreateHook(ctx context.Context, repo githubRepository, hook *github.Hook) (*github.Hook, error) {
+	client, err := p.clients.clientFor(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://developer.github.com/v3/repos/hooks/#create-a-hook
+	created, _, err := client.Repositories.CreateHook(ctx, repo.Org, repo.Name, hook)
+	return created, err
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v25/github"
+)
+
+// simulatedOrg is the organization in the fixtures that --simulate runs against
+const simulatedOrg = "acme-demo"
+
+// simulatedPipeline is a buildkite pipeline in the fixtures
+type simulatedPipeline struct {
+	ID         string
+	Slug       string
+	Repository string
+	Token      string
+	Teams      []string
+	Tags       []string
+	Settings   providerSettings
+}
+
+// simulatedPipelines and simulatedHooks are a small organization with the kinds of problems the
+// tool deals with: a hook with form payloads, an inactive hook, a legacy webhook.buildbox.io
+// hook that isn't known to any pipeline, and a pipeline without any hooks at all
+func simulatedPipelines() []simulatedPipeline {
+	return []simulatedPipeline{
+		{ID: "UGlwZWxpbmUtLS13ZWI=", Slug: "web", Repository: "git@github.com:acme-demo/web.git",
+			Token: "5f0c6b2e8a1d4c3b9e7f2a6d1c8b4e3f5a9d2c7b6e1f4a8d3c", Teams: []string{"frontend"}, Tags: []string{"production"},
+			Settings: providerSettings{TriggerMode: "code", BuildPullRequests: true}},
+		{ID: "UGlwZWxpbmUtLS1hcGk=", Slug: "api", Repository: "https://github.com/acme-demo/api.git",
+			Token: "8c3e1a7f5b2d9c4e6a1f8b3d7c2e5a9f4b1d6c8e3a7f2b5d9c", Teams: []string{"backend"}, Tags: []string{"production"},
+			Settings: providerSettings{TriggerMode: "code", BuildPullRequests: true}},
+		{ID: "UGlwZWxpbmUtLS1hcGktZGVwbG95", Slug: "api-deploy", Repository: "git@github.com:acme-demo/api.git",
+			Token: "2b7d4f9a1c6e3b8d5f2a7c4e9b1d6f3a8c5e2b7d4f9a1c6e3b", Teams: []string{"backend", "platform"},
+			Settings: providerSettings{TriggerMode: "deployment"}},
+		{ID: "UGlwZWxpbmUtLS1kb2Nz", Slug: "docs", Repository: "git@github.com:acme-demo/docs.git",
+			Token: "9e4a2c7f1b5d8e3a6c2f9b4d7e1a5c8f3b6d2e9a4c7f1b5d8e", Teams: []string{"docs"}},
+		{ID: "UGlwZWxpbmUtLS1zYW5kYm94", Slug: "sandbox", Repository: "git@github.com:acme-demo/sandbox.git",
+			Token: "4d8f2b6e9a3c7d1f5b8e2a6c9d3f7b1e4a8c2d6f9b3e7a1c5d"},
+	}
+}
+
+func simulatedHooks(pipelines []simulatedPipeline) map[string][]*github.Hook {
+	created := time.Date(2019, 3, 14, 9, 26, 53, 0, time.UTC)
+	updated := time.Date(2021, 11, 2, 16, 4, 12, 0, time.UTC)
+	webhook := func(p simulatedPipeline) string {
+		return "https://webhook.buildkite.com/deliver/" + p.Token
+	}
+	hook := func(id int64, url, contentType string, active bool, events ...string) *github.Hook {
+		return &github.Hook{
+			ID:        github.Int64(id),
+			Active:    github.Bool(active),
+			Events:    events,
+			CreatedAt: &created,
+			UpdatedAt: &updated,
+			Config: map[string]interface{}{
+				"url":          url,
+				"content_type": contentType,
+				"insecure_ssl": "0",
+			},
+		}
+	}
+	return map[string][]*github.Hook{
+		"acme-demo/web": {hook(1001, webhook(pipelines[0]), "json", true, "push", "pull_request")},
+		"acme-demo/api": {
+			hook(2001, webhook(pipelines[1]), "form", true, "push", "pull_request"),
+			hook(2002, webhook(pipelines[2]), "json", true, "deployment"),
+			hook(2003, "https://webhook.buildbox.io/github/0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d", "json", true, "push"),
+		},
+		"acme-demo/docs": {hook(3001, webhook(pipelines[3]), "json", false, "push")},
+	}
+}
+
+// simulation answers buildkite and github api requests from the fixtures, keeping track of
+// changes so the whole flow can be run without touching a real organization. Requests to
+// anything else fail, so nothing can leak out to a real service.
+type simulation struct {
+	mu         sync.Mutex
+	pipelines  []simulatedPipeline
+	hooks      map[string][]*github.Hook
+	deliveries map[int64][]hookDelivery
+	nextID     int64
+}
+
+func newSimulation() *simulation {
+	pipelines := simulatedPipelines()
+	return &simulation{
+		pipelines:  pipelines,
+		hooks:      simulatedHooks(pipelines),
+		deliveries: map[int64][]hookDelivery{},
+		nextID:     9001,
+	}
+}
+
+var simulatedHookPath = regexp.MustCompile(`^/repos/([^/]+/[^/]+)/hooks(?:/(\d+))?(/pings|/deliveries)?$`)
+
+func (s *simulation) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	switch req.URL.Host {
+	case "graphql.buildkite.com":
+		return s.graphql(req, body)
+	case "api.buildkite.com":
+		return s.rest(req)
+	case "api.github.com":
+		return s.github(req, body)
+	}
+	return nil, fmt.Errorf("%s isn't available when simulating", req.URL.Host)
+}
+
+func simulatedResponse(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	var b []byte
+	if v != nil {
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(b)),
+		Request:    req,
+	}, nil
+}
+
+func simulatedNotFound(req *http.Request) (*http.Response, error) {
+	return simulatedResponse(req, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (s *simulation) pipeline(id string) *simulatedPipeline {
+	for i := range s.pipelines {
+		if s.pipelines[i].ID == id || s.pipelines[i].Slug == id {
+			return &s.pipelines[i]
+		}
+	}
+	return nil
+}
+
+func (s *simulation) node(p simulatedPipeline) map[string]interface{} {
+	teams := []interface{}{}
+	for _, team := range p.Teams {
+		teams = append(teams, map[string]interface{}{"node": map[string]interface{}{"team": map[string]string{"slug": team}}})
+	}
+	tags := []interface{}{}
+	for _, tag := range p.Tags {
+		tags = append(tags, map[string]string{"label": tag})
+	}
+	return map[string]interface{}{
+		"__typename":   "Pipeline",
+		"id":           p.ID,
+		"slug":         p.Slug,
+		"url":          fmt.Sprintf("https://buildkite.com/%s/%s", simulatedOrg, p.Slug),
+		"visibility":   "PRIVATE",
+		"archived":     false,
+		"organization": map[string]string{"slug": simulatedOrg},
+		"teams":        map[string]interface{}{"edges": teams},
+		"tags":         tags,
+		"builds": map[string]interface{}{"edges": []interface{}{
+			map[string]interface{}{"node": map[string]interface{}{"createdAt": time.Now().Add(-26 * time.Hour).UTC()}},
+		}},
+		"repository": map[string]interface{}{
+			"url": p.Repository,
+			"provider": map[string]string{
+				"__typename": githubRepositoryProvider,
+				"webhookUrl": "https://webhook.buildkite.com/deliver/" + p.Token,
+			},
+		},
+	}
+}
+
+func (s *simulation) graphql(req *http.Request, body []byte) (*http.Response, error) {
+	var gqlReq struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(body, &gqlReq); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.Contains(gqlReq.Query, "pipelineRotateWebhookURL"):
+		input, _ := gqlReq.Variables["input"].(map[string]interface{})
+		id, _ := input["id"].(string)
+		p := s.pipeline(id)
+		if p == nil {
+			break
+		}
+		token := make([]byte, 25)
+		rand.Read(token)
+		p.Token = hex.EncodeToString(token)
+		return simulatedResponse(req, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"pipelineRotateWebhookURL": map[string]interface{}{"pipeline": map[string]string{
+				"webhookURL": "https://webhook.buildkite.com/deliver/" + p.Token,
+			}},
+		}})
+
+	case strings.Contains(gqlReq.Query, "query ListPipelines"):
+		if gqlReq.Variables["org"] != simulatedOrg {
+			return simulatedResponse(req, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"organization": nil}})
+		}
+		edges := []interface{}{}
+		for _, p := range s.pipelines {
+			edges = append(edges, map[string]interface{}{"node": s.node(p)})
+		}
+		return simulatedResponse(req, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"organization": map[string]interface{}{"pipelines": map[string]interface{}{
+				"pageInfo": map[string]interface{}{"hasNextPage": false, "endCursor": ""},
+				"edges":    edges,
+			}},
+		}})
+
+	case strings.Contains(gqlReq.Query, "query GetPipeline"):
+		id, _ := gqlReq.Variables["id"].(string)
+		if p := s.pipeline(id); p != nil {
+			return simulatedResponse(req, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"node": s.node(*p)}})
+		}
+		return simulatedResponse(req, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"node": nil}})
+	}
+
+	return simulatedResponse(req, http.StatusOK, map[string]interface{}{
+		"errors": []map[string]string{{"message": "This query isn't available when simulating"}},
+	})
+}
+
+func (s *simulation) rest(req *http.Request) (*http.Response, error) {
+	prefix := fmt.Sprintf("/v2/organizations/%s/pipelines/", simulatedOrg)
+	if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, prefix) {
+		if p := s.pipeline(strings.TrimPrefix(req.URL.Path, prefix)); p != nil {
+			return simulatedResponse(req, http.StatusOK, map[string]interface{}{
+				"provider": map[string]interface{}{"id": "github", "settings": p.Settings},
+			})
+		}
+	}
+	return simulatedNotFound(req)
+}
+
+func (s *simulation) hook(repo string, id int64) *github.Hook {
+	for _, hook := range s.hooks[repo] {
+		if hook.GetID() == id {
+			return hook
+		}
+	}
+	return nil
+}
+
+// accepts is whether buildkite would accept a delivery to a hook, which it does as long as
+// the hook is active and its url has a pipeline's current webhook token
+func (s *simulation) accepts(hook *github.Hook) bool {
+	for _, p := range s.pipelines {
+		if hook.GetActive() && hookURL(hook) == "https://webhook.buildkite.com/deliver/"+p.Token {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *simulation) github(req *http.Request, body []byte) (*http.Response, error) {
+	if req.URL.Path == "/user" {
+		return simulatedResponse(req, http.StatusOK, map[string]string{"login": "demo-operator"})
+	}
+
+	match := simulatedHookPath.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		return simulatedNotFound(req)
+	}
+	repo := match[1]
+
+	if match[2] == "" {
+		switch req.Method {
+		case http.MethodGet:
+			hooks := s.hooks[repo]
+			if hooks == nil {
+				hooks = []*github.Hook{}
+			}
+			return simulatedResponse(req, http.StatusOK, hooks)
+		case http.MethodPost:
+			var create github.Hook
+			if err := json.Unmarshal(body, &create); err != nil {
+				return nil, err
+			}
+			now := time.Now().UTC()
+			create.ID, create.CreatedAt, create.UpdatedAt = github.Int64(s.nextID), &now, &now
+			s.nextID++
+			s.hooks[repo] = append(s.hooks[repo], &create)
+			return simulatedResponse(req, http.StatusCreated, create)
+		}
+		return simulatedNotFound(req)
+	}
+
+	id, _ := strconv.ParseInt(match[2], 10, 64)
+	hook := s.hook(repo, id)
+	if hook == nil {
+		return simulatedNotFound(req)
+	}
+
+	switch {
+	case match[3] == "" && req.Method == http.MethodGet:
+		return simulatedResponse(req, http.StatusOK, hook)
+
+	case match[3] == "" && req.Method == http.MethodPatch:
+		var edit github.Hook
+		if err := json.Unmarshal(body, &edit); err != nil {
+			return nil, err
+		}
+		for k, v := range edit.Config {
+			hook.Config[k] = v
+		}
+		if edit.Active != nil {
+			hook.Active = edit.Active
+		}
+		if edit.Events != nil {
+			hook.Events = edit.Events
+		}
+		now := time.Now().UTC()
+		hook.UpdatedAt = &now
+		return simulatedResponse(req, http.StatusOK, hook)
+
+	case match[3] == "/pings" && req.Method == http.MethodPost:
+		delivery := hookDelivery{ID: s.nextID, Event: "ping", DeliveredAt: time.Now().UTC(), StatusCode: http.StatusOK, Status: "OK"}
+		if !s.accepts(hook) {
+			delivery.StatusCode, delivery.Status = http.StatusNotFound, "Not Found"
+		}
+		s.nextID++
+		s.deliveries[id] = append([]hookDelivery{delivery}, s.deliveries[id]...)
+		return simulatedResponse(req, http.StatusNoContent, nil)
+
+	case match[3] == "/deliveries" && req.Method == http.MethodGet:
+		deliveries := s.deliveries[id]
+		if deliveries == nil {
+			deliveries = []hookDelivery{}
+		}
+		return simulatedResponse(req, http.StatusOK, deliveries)
+	}
+	return simulatedNotFound(req)
+}
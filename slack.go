@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// slackApprover posts a rotation plan to slack with approve and reject buttons, and waits
+// for someone to click one of them. Slack delivers the clicks to an interactivity request url,
+// which needs to be routed to the listen address.
+//
+// https://api.slack.com/interactivity/handling
+type slackApprover struct {
+	Token         string
+	Channel       string
+	SigningSecret string
+	ListenAddr    string
+	Timeout       time.Duration
+}
+
+type slackDecision struct {
+	Approved bool
+	User     string
+}
+
+// requestApproval blocks until the plan is approved or rejected in slack, returning who made the decision
+func (s *slackApprover) requestApproval(ctx context.Context, summary string) (slackDecision, error) {
+	id, err := randomID()
+	if err != nil {
+		return slackDecision{}, err
+	}
+
+	decisions := make(chan slackDecision, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !s.verifySignature(r.Header, body) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var payload struct {
+			User struct {
+				ID       string `json:"id"`
+				Username string `json:"username"`
+			} `json:"user"`
+			Actions []struct {
+				ActionID string `json:"action_id"`
+				Value    string `json:"value"`
+			} `json:"actions"`
+			ResponseURL string `json:"response_url"`
+		}
+
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		for _, action := range payload.Actions {
+			// ignore buttons from earlier runs
+			if action.Value != id {
+				continue
+			}
+
+			decision := slackDecision{Approved: action.ActionID == "approve", User: payload.User.Username}
+			outcome := "rejected"
+			if decision.Approved {
+				outcome = "approved"
+			}
+
+			s.respond(payload.ResponseURL, fmt.Sprintf("Webhook rotation %s by <@%s>", outcome, payload.User.ID))
+
+			select {
+			case decisions <- decision:
+			default:
+			}
+		}
+	})
+
+	server := &http.Server{Addr: s.ListenAddr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Slack interactivity server failed: %v", err)
+		}
+	}()
+	defer server.Close()
+
+	if err := s.postPlan(id, summary); err != nil {
+		return slackDecision{}, err
+	}
+
+	log.Printf("Waiting for approval in slack channel %s", s.Channel)
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	select {
+	case decision := <-decisions:
+		return decision, nil
+	case <-ctx.Done():
+		return slackDecision{}, fmt.Errorf("Timed out waiting for approval in slack after %v", s.Timeout)
+	}
+}
+
+func (s *slackApprover) postPlan(id, summary string) error {
+	// slack limits section text to 3000 characters
+	if len(summary) > 2900 {
+		summary = summary[:2900] + "\n…"
+	}
+
+	message := map[string]interface{}{
+		"channel": s.Channel,
+		"text":    "Buildkite webhook rotation needs approval",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{
+					"type": "mrkdwn",
+					"text": "*Buildkite webhook rotation needs approval*\n```" + summary + "```",
+				},
+			},
+			map[string]interface{}{
+				"type": "actions",
+				"elements": []interface{}{
+					slackButton("approve", "Approve", "primary", id),
+					slackButton("reject", "Reject", "danger", id),
+				},
+			},
+		},
+	}
+
+	return postSlackAPI("https://slack.com/api/chat.postMessage", s.Token, message)
+}
+
+func (s *slackApprover) respond(responseURL, text string) {
+	err := postSlackAPI(responseURL, "", map[string]interface{}{
+		"replace_original": false,
+		"text":             text,
+	})
+	if err != nil {
+		log.Printf("Failed to respond in slack: %v", err)
+	}
+}
+
+// verifySignature checks a request came from slack
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func (s *slackApprover) verifySignature(header http.Header, body []byte) bool {
+	timestamp, err := strconv.ParseInt(header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil || math.Abs(float64(time.Now().Unix()-timestamp)) > 300 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.SigningSecret))
+	fmt.Fprintf(mac, "v0:%d:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
+
+func slackButton(actionID, text, style, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "button",
+		"action_id": actionID,
+		"style":     style,
+		"value":     value,
+		"text": map[string]interface{}{
+			"type": "plain_text",
+			"text": text,
+		},
+	}
+}
+
+func postSlackAPI(url, token string, message interface{}) error {
+	b, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack responded with %s", resp.Status)
+	}
+
+	// the web api returns errors with a 200 status
+	var parsedResp struct {
+		OK    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsedResp); err == nil && parsedResp.OK != nil && !*parsedResp.OK {
+		return fmt.Errorf("Slack error: %s", parsedResp.Error)
+	}
+
+	return nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint records the pipelines a run has rotated so far, so that a run that is
+// terminated part way through can be resumed by the next one without rotating the same
+// pipelines twice. It's kept in a state directory, like a volume mounted into a cronjob.
+type checkpoint struct {
+	path string
+
+	StartedAt time.Time `json:"started_at"`
+	Completed []string  `json:"completed"`
+}
+
+func checkpointPath(dir, org string) string {
+	return filepath.Join(dir, fmt.Sprintf("checkpoint-%s.json", org))
+}
+
+// openCheckpoint continues the checkpoint of an earlier run that didn't finish, or
+// starts a new one
+func openCheckpoint(dir, org string) (*checkpoint, error) {
+	c := &checkpoint{path: checkpointPath(dir, org), StartedAt: time.Now().UTC()}
+
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, os.MkdirAll(dir, 0700)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse checkpoint %s: %v", c.path, err)
+	}
+	return c, nil
+}
+
+func (c *checkpoint) completed(pipelineID string) bool {
+	if c == nil {
+		return false
+	}
+	for _, id := range c.Completed {
+		if id == pipelineID {
+			return true
+		}
+	}
+	return false
+}
+
+// complete records a pipeline as done, writing the checkpoint straight away so it
+// survives the process being killed
+func (c *checkpoint) complete(pipelineID string) error {
+	if c == nil {
+		return nil
+	}
+	c.Completed = append(c.Completed, pipelineID)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a kill part way through doesn't leave a truncated checkpoint
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// clear removes the checkpoint once a run has processed every pipeline
+func (c *checkpoint) clear() error {
+	if c == nil {
+		return nil
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// resultStream writes a json record per line as each pipeline is processed, so that
+// dashboards can follow a long run rather than waiting for the report at the end
+type resultStream struct {
+	f *os.File
+
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+type streamRecord struct {
+	Time       time.Time    `json:"time"`
+	Phase      string       `json:"phase"`
+	Pipeline   string       `json:"pipeline"`
+	PipelineID string       `json:"pipeline_id"`
+	Outcome    string       `json:"outcome,omitempty"`
+	Reason     string       `json:"reason,omitempty"`
+	Hooks      []hookResult `json:"hooks,omitempty"`
+	DurationMS int64        `json:"duration_ms,omitempty"`
+}
+
+// openResultStream appends to a file, which can also be a named pipe
+func openResultStream(path string) (*resultStream, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &resultStream{f: f, started: map[string]time.Time{}}, nil
+}
+
+// start records that a pipeline is being processed
+func (s *resultStream) start(p pipeline) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.started[p.ID] = time.Now()
+	s.mu.Unlock()
+
+	s.write(streamRecord{
+		Time:       time.Now().UTC(),
+		Phase:      "started",
+		Pipeline:   p.String(),
+		PipelineID: p.ID,
+	})
+}
+
+// finish records the outcome of a pipeline and how long it took
+func (s *resultStream) finish(result pipelineResult) {
+	if s == nil {
+		return
+	}
+	record := streamRecord{
+		Time:       time.Now().UTC(),
+		Phase:      "finished",
+		Pipeline:   result.Pipeline,
+		PipelineID: result.PipelineID,
+		Outcome:    result.Outcome,
+		Reason:     result.Reason,
+		Hooks:      result.Hooks,
+	}
+
+	s.mu.Lock()
+	if started, ok := s.started[result.PipelineID]; ok {
+		record.DurationMS = int64(time.Since(started) / time.Millisecond)
+	}
+	s.mu.Unlock()
+
+	s.write(record)
+}
+
+// write ignores errors, a dashboard going away shouldn't stop a rotation
+func (s *resultStream) write(record streamRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Write(append(line, '\n'))
+}
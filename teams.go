@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// teamsSummaryCard builds an adaptive card summarizing a run, for posting to a microsoft
+// teams incoming webhook
+//
+// https://learn.microsoft.com/en-us/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using
+func teamsSummaryCard(report *runReport) map[string]interface{} {
+	summary := report.summary()
+
+	var facts []interface{}
+	for _, key := range []string{"rotated", "partial", "failed", "skipped"} {
+		facts = append(facts, map[string]interface{}{"title": key, "value": summary[key]})
+	}
+
+	body := []interface{}{
+		map[string]interface{}{
+			"type":   "TextBlock",
+			"size":   "Medium",
+			"weight": "Bolder",
+			"text":   fmt.Sprintf("Buildkite webhook rotation for %s", report.Organization),
+		},
+		map[string]interface{}{
+			"type":  "FactSet",
+			"facts": facts,
+		},
+	}
+
+	// list what needs a manual fix, which is what readers of the channel need to act on
+	for _, result := range report.Pipelines {
+		if result.Outcome != outcomeFailed && result.Outcome != outcomePartial {
+			continue
+		}
+		text := fmt.Sprintf("**%s** [%s](https://buildkite.com/%s)", result.Outcome, result.Pipeline, result.Pipeline)
+		text += ": " + firstNonEmpty(result.Reason, fmt.Sprintf("%d hooks need a manual fix", result.failedHooks()))
+		body = append(body, map[string]interface{}{
+			"type":  "TextBlock",
+			"wrap":  true,
+			"color": "Attention",
+			"text":  text,
+		})
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			},
+		},
+	}
+}
+
+func postTeamsSummary(webhookURL string, report *runReport) error {
+	b, err := json.Marshal(teamsSummaryCard(report))
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Post(webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Microsoft Teams responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v25/github"
+)
+
+var terraformInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// terraformName returns a resource name unique to a repository hook
+func terraformName(repo githubRepository, hook *github.Hook) string {
+	name := terraformInvalidChars.ReplaceAllString(fmt.Sprintf("%s_%s", repo.Org, repo.Name), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return strings.ToLower(fmt.Sprintf("%s_%d", name, *hook.ID))
+}
+
+// writeTerraform emits github_repository_webhook resources and the commands to import them
+// for all the buildkite hooks in the inventory. Webhook urls are credentials, so they are
+// left as sensitive variables rather than written into the configuration.
+func writeTerraform(w io.Writer, inv *inventory) error {
+	type terraformHook struct {
+		repo     githubRepository
+		hook     *github.Hook
+		pipeline string
+	}
+
+	var hooks []terraformHook
+	for _, repo := range inv.repositories() {
+		for _, hook := range inv.RepositoryHooks[repo.String()] {
+			pipeline := "unknown pipeline"
+			if token, err := getWebhookToken(hookURL(hook)); err == nil {
+				for _, p := range inv.Pipelines {
+					if p.WebhookToken == token {
+						pipeline = "https://buildkite.com/" + p.String()
+					}
+				}
+			}
+			hooks = append(hooks, terraformHook{repo, hook, pipeline})
+		}
+	}
+
+	fmt.Fprintf(w, "# Buildkite webhooks discovered for %s\n", inv.Org)
+	fmt.Fprintf(w, "#\n# Import the existing hooks with:\n")
+	for _, h := range hooks {
+		fmt.Fprintf(w, "#   terraform import github_repository_webhook.%s %s/%d\n",
+			terraformName(h.repo, h.hook), h.repo.Name, *h.hook.ID)
+	}
+
+	for _, h := range hooks {
+		name := terraformName(h.repo, h.hook)
+
+		fmt.Fprintf(w, "\nvariable %q {\n", name+"_url")
+		fmt.Fprintf(w, "  description = %q\n", fmt.Sprintf("Buildkite webhook url for %s (%s)", h.repo, h.pipeline))
+		fmt.Fprintf(w, "  type        = string\n")
+		fmt.Fprintf(w, "  sensitive   = true\n")
+		fmt.Fprintf(w, "}\n")
+
+		var events []string
+		for _, event := range h.hook.Events {
+			events = append(events, strconv.Quote(event))
+		}
+
+		fmt.Fprintf(w, "\n# %s/settings/hooks/%d\n", h.repo.URL(), *h.hook.ID)
+		fmt.Fprintf(w, "resource \"github_repository_webhook\" %q {\n", name)
+		fmt.Fprintf(w, "  repository = %q\n", h.repo.Name)
+		fmt.Fprintf(w, "  active     = %t\n", h.hook.GetActive())
+		fmt.Fprintf(w, "  events     = [%s]\n", strings.Join(events, ", "))
+		fmt.Fprintf(w, "\n  configuration {\n")
+		fmt.Fprintf(w, "    url          = var.%s_url\n", name)
+		fmt.Fprintf(w, "    content_type = %q\n", hookContentType(h.hook))
+		fmt.Fprintf(w, "    insecure_ssl = %t\n", hookInsecureSSL(h.hook))
+		fmt.Fprintf(w, "  }\n")
+		fmt.Fprintf(w, "}\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v25/github"
+)
+
+func TestWriteTerraformHookConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "string insecure_ssl",
+			config: map[string]interface{}{"content_type": "json", "insecure_ssl": "1"},
+			want:   []string{`content_type = "json"`, "insecure_ssl = true"},
+		},
+		{
+			name:   "numeric insecure_ssl",
+			config: map[string]interface{}{"content_type": "json", "insecure_ssl": float64(1)},
+			want:   []string{`content_type = "json"`, "insecure_ssl = true"},
+		},
+		{
+			name:   "verifying ssl",
+			config: map[string]interface{}{"content_type": "json", "insecure_ssl": "0"},
+			want:   []string{"insecure_ssl = false"},
+		},
+		{
+			name:   "default content type",
+			config: map[string]interface{}{},
+			want:   []string{`content_type = "form"`, "insecure_ssl = false"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config["url"] = "https://webhook.buildkite.com/deliver/5f0c6b2e8a1d"
+			repo := githubRepository{Host: defaultGithubHost, Org: "acme", Name: "web"}
+			inv := newInventory("acme", []pipeline{{Org: "acme", Slug: "web", Repository: repo, WebhookToken: "5f0c6b2e8a1d"}},
+				map[string][]*github.Hook{repo.String(): {{ID: github.Int64(1001), Active: github.Bool(true), Config: tc.config}}})
+
+			var b bytes.Buffer
+			if err := writeTerraform(&b, inv); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(b.String(), want) {
+					t.Fatalf("Expected %q in:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
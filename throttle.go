@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// alertThrottle dedupes alerts when running continuously, so a problem that persists from
+// one check to the next, like a repository the token can't access, is alerted once and
+// then only reminded about every so often rather than on every interval
+type alertThrottle struct {
+	alert func(message string)
+	every time.Duration
+
+	// alerted is when each message was last alerted, and seen is the messages sent since
+	// the last round of checks
+	alerted map[string]time.Time
+	seen    map[string]bool
+}
+
+func newAlertThrottle(alert func(string), every time.Duration) *alertThrottle {
+	return &alertThrottle{alert: alert, every: every, alerted: map[string]time.Time{}, seen: map[string]bool{}}
+}
+
+// send alerts a message unless the same one was alerted recently, an every of zero only
+// alerts it once for as long as it persists
+func (t *alertThrottle) send(message string) {
+	if t.alert == nil {
+		return
+	}
+	t.seen[message] = true
+
+	if last, ok := t.alerted[message]; ok && (t.every == 0 || time.Since(last) < t.every) {
+		log.Printf("Not alerting again about a problem first alerted at %s", last.Format(time.RFC3339))
+		return
+	}
+	t.alerted[message] = time.Now()
+	t.alert(message)
+}
+
+// round marks the end of a round of checks, forgetting problems that weren't seen in
+// it so they're alerted straight away if they come back
+func (t *alertThrottle) round() {
+	for message := range t.alerted {
+		if !t.seen[message] {
+			delete(t.alerted, message)
+		}
+	}
+	t.seen = map[string]bool{}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keychainService is the service tokens are stored under in the os keychain
+const keychainService = "github-webhook-rotate"
+
+// tokenSources are the places a token can come from, in order of precedence
+type tokenSources struct {
+	// Flag is the value of the token flag, - to read it from stdin
+	Flag string
+
+	// File is a file to read the token from
+	File string
+
+	// Env are the environment variables that can hold the token, most specific first
+	Env []string
+
+	// KeychainAccount is the account the token is stored under in the os keychain
+	KeychainAccount string
+}
+
+// resolve finds the token from the first source that has one, or returns an empty string
+func (s tokenSources) resolve(stdin *bufio.Reader) (string, error) {
+	if s.Flag == "-" {
+		return readTokenLine(stdin)
+	} else if s.Flag != "" {
+		return s.Flag, nil
+	}
+	if s.File != "" {
+		return readTokenFile(s.File)
+	}
+	for _, env := range s.Env {
+		if token := strings.TrimSpace(os.Getenv(env)); token != "" {
+			return token, nil
+		}
+	}
+	if s.KeychainAccount != "" {
+		return readKeychain(s.KeychainAccount), nil
+	}
+	return "", nil
+}
+
+// readTokenFile reads a secret from a file, such as a mounted kubernetes secret
+func readTokenFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("Token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// readTokenLine reads a secret from a line of input, so that wrapper scripts can pipe them in
+func readTokenLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("Token is empty")
+	}
+	return token, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// readKeychain looks up a token in the macOS keychain or the secret service on linux,
+// returning an empty string if it isn't there
+func readKeychain(account string) string {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	default:
+		return ""
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
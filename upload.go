@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/oauth2/google"
+)
+
+// artifactUploader stores run artifacts somewhere more durable than the local disk
+type artifactUploader interface {
+	upload(ctx context.Context, name string, data []byte) error
+	String() string
+}
+
+// newArtifactUploader parses a destination like s3://bucket/prefix or gs://bucket/prefix
+func newArtifactUploader(ctx context.Context, dest string) (artifactUploader, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "s3":
+		sess, err := session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &s3Uploader{
+			bucket:   u.Host,
+			prefix:   strings.Trim(u.Path, "/"),
+			uploader: s3manager.NewUploader(sess),
+		}, nil
+	case "gs":
+		client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+		if err != nil {
+			return nil, err
+		}
+		return &gcsUploader{
+			bucket: u.Host,
+			prefix: strings.Trim(u.Path, "/"),
+			client: client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported report destination %q, expected s3://bucket/prefix or gs://bucket/prefix", dest)
+	}
+}
+
+type s3Uploader struct {
+	bucket   string
+	prefix   string
+	uploader *s3manager.Uploader
+}
+
+func (s *s3Uploader) upload(ctx context.Context, name string, data []byte) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(path.Join(s.prefix, name)),
+		Body:                 bytes.NewReader(data),
+		ServerSideEncryption: aws.String("AES256"),
+	})
+	return err
+}
+
+func (s *s3Uploader) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+type gcsUploader struct {
+	bucket string
+	prefix string
+	client *http.Client
+}
+
+// https://cloud.google.com/storage/docs/uploading-objects#uploading-an-object
+func (g *gcsUploader) upload(ctx context.Context, name string, data []byte) error {
+	endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		url.PathEscape(g.bucket), url.QueryEscape(path.Join(g.prefix, name)))
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Google Cloud Storage responded with %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+func (g *gcsUploader) String() string {
+	return fmt.Sprintf("gs://%s/%s", g.bucket, g.prefix)
+}
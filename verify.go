@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// hookCheck is the outcome of pinging a hook at its current webhook
+type hookCheck struct {
+	pipeline pipeline
+	match    githubRepositoryHook
+	err      error
+}
+
+// verifyCurrentHooks pings every hook that refers to a pipeline in the inventory and checks
+// buildkite accepts deliveries on the current webhooks. Nothing is changed.
+func verifyCurrentHooks(ctx context.Context, ghClients *githubClients, inv *inventory, concurrency int) []hookCheck {
+	var checks []hookCheck
+	var mu sync.Mutex
+	g := newBoundedGroup(ctx, concurrency)
+
+	for _, p := range inv.Pipelines {
+		for _, match := range inv.TokenHooks[p.WebhookToken] {
+			p, match := p, match
+			g.do(func(ctx context.Context) error {
+				client, err := ghClients.clientFor(match.githubRepository)
+				if err == nil {
+					err = verifyHookPing(ctx, client, match)
+				}
+
+				mu.Lock()
+				checks = append(checks, hookCheck{p, match, err})
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+	g.wait()
+
+	sort.Slice(checks, func(i, j int) bool {
+		if checks[i].pipeline.String() != checks[j].pipeline.String() {
+			return checks[i].pipeline.String() < checks[j].pipeline.String()
+		}
+		return *checks[i].match.Hook.ID < *checks[j].match.Hook.ID
+	})
+	return checks
+}
+
+// printHookChecks shows the outcome of each ping and returns how many failed
+func printHookChecks(w io.Writer, checks []hookCheck, alert func(string)) int {
+	failed := 0
+	for _, check := range checks {
+		hook := fmt.Sprintf("%s/settings/hooks/%d", check.match.githubRepository.URL(), *check.match.Hook.ID)
+		if check.err == nil {
+			fmt.Fprintf(w, "✅ https://buildkite.com/%s\t%s\n", check.pipeline.String(), hook)
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(w, color.RedString("🚨 https://buildkite.com/%s\t%s: %v\n"), check.pipeline.String(), hook, check.err)
+		if alert != nil {
+			alert(fmt.Sprintf("Buildkite didn't accept a ping to %s for https://buildkite.com/%s: %v",
+				hook, check.pipeline.String(), check.err))
+		}
+	}
+	fmt.Fprintf(w, "\n%d hooks verified, %d failed\n", len(checks), failed)
+	return failed
+}
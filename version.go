@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// these are set at build time with -ldflags, e.g.
+// -X main.version=1.2.0 -X main.commit=abc123 -X main.buildDate=2019-06-01T00:00:00Z
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildVersion is the version of the running build, falling back to the module version
+// for builds installed with go get
+func buildVersion() string {
+	if version == "dev" {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			return info.Main.Version
+		}
+	}
+	return version
+}
+
+// versionString describes the build precisely enough to know which one performed a rotation
+func versionString() string {
+	s := buildVersion()
+	if commit != "" {
+		s += fmt.Sprintf(" (commit %s)", commit)
+	}
+	if buildDate != "" {
+		s += fmt.Sprintf(" built %s", buildDate)
+	}
+	return s + fmt.Sprintf(" %s", runtime.Version())
+}
+
+// checkForUpdate prints a notice if there's a newer release than the running build, since
+// old builds may not handle the current webhook url formats
+func checkForUpdate(ctx context.Context) {
+	current := buildVersion()
+	if _, ok := parseVersion(current); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	release, _, err := github.NewClient(nil).Repositories.GetLatestRelease(ctx, "buildkite", "github-webhook-rotate")
+	if err != nil {
+		return
+	}
+	if newerVersion(release.GetTagName(), current) {
+		log.Printf(color.YellowString("⚠️  github-webhook-rotate %s is available, this is %s: %s",
+			release.GetTagName(), current, release.GetHTMLURL()))
+	}
+}
+
+// parseVersion parses a semantic version like v1.2.3, ignoring any pre-release or build suffix
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+func newerVersion(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	// a release is newer than its pre-releases, like v1.2.0 is newer than v1.2.0-rc.1
+	return isPrerelease(current) && !isPrerelease(latest)
+}
+
+// isPrerelease is whether a version has a pre-release suffix, which comes before any build metadata
+func isPrerelease(v string) bool {
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.Contains(v, "-")
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    [3]int
+		valid   bool
+	}{
+		{"v1.2.3", [3]int{1, 2, 3}, true},
+		{"1.2.3", [3]int{1, 2, 3}, true},
+		{"v10.20.30", [3]int{10, 20, 30}, true},
+		{"v1.2.3-rc.1", [3]int{1, 2, 3}, true},
+		{"v1.2.3+build.5", [3]int{1, 2, 3}, true},
+		{"v1.2.3-rc.1+build.5", [3]int{1, 2, 3}, true},
+		{"v0.0.0-20190601120000-abcdef123456", [3]int{0, 0, 0}, true},
+		{"dev", [3]int{}, false},
+		{"", [3]int{}, false},
+		{"v1.2", [3]int{}, false},
+		{"v1.2.3.4", [3]int{}, false},
+		{"v1.x.3", [3]int{}, false},
+		{"vv1.2.3", [3]int{}, false},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			got, ok := parseVersion(tc.version)
+			if ok != tc.valid {
+				t.Fatalf("Expected %q to parse %v, got %v", tc.version, tc.valid, ok)
+			}
+			if ok && got != tc.want {
+				t.Fatalf("Expected %q to parse as %v, got %v", tc.version, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	for _, tc := range []struct {
+		latest, current string
+		newer           bool
+	}{
+		{"v1.2.4", "v1.2.3", true},
+		{"v1.3.0", "v1.2.9", true},
+		{"v2.0.0", "v1.9.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "1.2.3", false},
+		{"v1.2.2", "v1.2.3", false},
+		{"v1.9.9", "v2.0.0", false},
+		{"v1.2.3", "v1.2.3-rc.1", true},
+		{"v1.2.3-rc.2", "v1.2.3-rc.1", false},
+		{"v1.2.3-rc.1", "v1.2.3", false},
+		{"v1.2.3", "v1.2.3+build.5", false},
+		{"v1.2.3+build.5", "v1.2.3-rc.1", true},
+		{"v1.2.3", "v1.2.4-0.20190601120000-abcdef123456", false},
+		{"v1.2.4", "v1.2.4-0.20190601120000-abcdef123456", true},
+		{"v1.2.3", "dev", false},
+		{"latest", "v1.2.3", false},
+	} {
+		t.Run(tc.latest+" "+tc.current, func(t *testing.T) {
+			if got := newerVersion(tc.latest, tc.current); got != tc.newer {
+				t.Fatalf("Expected newerVersion(%q, %q) to be %v, got %v", tc.latest, tc.current, tc.newer, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/go-github/v25/github"
+)
+
+// deliveryWatcher polls the deliveries of recently updated hooks for a while after rotation
+// and alerts when they fail, to catch breakage that only shows up with real events
+type deliveryWatcher struct {
+	ghClients *githubClients
+	hooks     []githubRepositoryHook
+	since     time.Time
+	interval  time.Duration
+
+	// alert is called for each failed delivery as well as logging it
+	alert func(message string)
+}
+
+// updatedHooks returns the hooks that were updated in a run
+func updatedHooks(report *runReport) []githubRepositoryHook {
+	var hooks []githubRepositoryHook
+	for _, result := range report.Pipelines {
+		for _, hook := range result.Hooks {
+			if !hook.Updated {
+				continue
+			}
+			repo, err := parseRepositoryName(hook.Repository)
+			if err != nil {
+				continue
+			}
+			// hooks that had been deleted were replaced by a new one, which is the one to watch
+			id := hook.ID
+			if hook.RecreatedID != 0 {
+				id = hook.RecreatedID
+			}
+			hooks = append(hooks, githubRepositoryHook{repo, &github.Hook{ID: github.Int64(id)}})
+		}
+	}
+	return hooks
+}
+
+// watch polls until the window has passed, returning the number of hooks with failed deliveries
+func (w *deliveryWatcher) watch(ctx context.Context, window time.Duration) int {
+	log.Printf("Watching deliveries to %d hooks for %v", len(w.hooks), window)
+
+	seen := map[int64]bool{}
+	failing := map[string]bool{}
+	deadline := time.Now().Add(window)
+
+	for {
+		for _, hook := range w.hooks {
+			var deliveries []hookDelivery
+			ghClient, err := w.ghClients.clientFor(hook.githubRepository)
+			if err == nil {
+				deliveries, err = listHookDeliveries(ctx, ghClient, hook)
+			}
+			if err != nil {
+				log.Printf(color.YellowString("⚠️  Failed to read deliveries for %s/settings/hooks/%d: %v",
+					hook.githubRepository.URL(), *hook.Hook.ID, err))
+				continue
+			}
+
+			for _, delivery := range deliveries {
+				if seen[delivery.ID] || delivery.DeliveredAt.Before(w.since) {
+					continue
+				}
+				seen[delivery.ID] = true
+
+				if delivery.StatusCode >= 200 && delivery.StatusCode <= 299 {
+					continue
+				}
+
+				failing[fmt.Sprintf("%s/%d", hook.githubRepository.String(), *hook.Hook.ID)] = true
+				message := fmt.Sprintf("Delivery of a %s event to %s/settings/hooks/%d failed with %d %s",
+					delivery.Event, hook.githubRepository.URL(), *hook.Hook.ID, delivery.StatusCode, delivery.Status)
+				log.Printf(color.RedString("🚨 %s", message))
+				if w.alert != nil {
+					w.alert(message)
+				}
+			}
+		}
+
+		if time.Now().Add(w.interval).After(deadline) {
+			break
+		}
+		time.Sleep(w.interval)
+	}
+
+	return len(failing)
+}
+
+func readRunReport(path string) (*runReport, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report runReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("Failed to parse report %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// slackAlerter posts alerts to a slack channel
+func slackAlerter(token, channel string) func(string) {
+	return func(message string) {
+		err := postSlackAPI("https://slack.com/api/chat.postMessage", token, map[string]interface{}{
+			"channel": channel,
+			"text":    ":rotating_light: " + message,
+		})
+		if err != nil {
+			log.Printf("Failed to alert in slack: %v", err)
+		}
+	}
+}